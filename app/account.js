@@ -0,0 +1,102 @@
+document.addEventListener('DOMContentLoaded', async () => {
+  const token = new URLSearchParams(window.location.search).get('token');
+
+  if (document.getElementById('verify-email-section')) {
+    await verifyEmail(token);
+    return;
+  }
+
+  if (token) {
+    document.getElementById('reset-confirm-section').style.display = 'block';
+  } else {
+    document.getElementById('reset-request-section').style.display = 'block';
+  }
+});
+
+document.getElementById('reset-request-form')?.addEventListener('submit', async (event) => {
+  event.preventDefault();
+  await requestPasswordReset();
+});
+
+document.getElementById('reset-confirm-form')?.addEventListener('submit', async (event) => {
+  event.preventDefault();
+  await confirmPasswordReset();
+});
+
+async function verifyEmail(token) {
+  const status = document.getElementById('verify-email-status');
+  if (!token) {
+    status.textContent = 'This verification link is missing its token.';
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/email_verification/confirm', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ token }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(data.error);
+    }
+    status.textContent = 'Your email address is verified. You can close this page.';
+  } catch (error) {
+    status.textContent = `Couldn't verify your email: ${error.message}`;
+  }
+}
+
+async function requestPasswordReset() {
+  const email = document.getElementById('reset-email').value;
+
+  try {
+    const res = await fetch('/api/password_reset', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ email }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(data.error);
+    }
+    document.getElementById('reset-request-section').style.display = 'none';
+    document.getElementById('reset-status').textContent =
+      'If an account exists for that email, a reset link is on its way.';
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function confirmPasswordReset() {
+  const token = new URLSearchParams(window.location.search).get('token');
+  const password = document.getElementById('new-password').value;
+  const confirmation = document.getElementById('confirm-password').value;
+  if (password !== confirmation) {
+    alert('Passwords do not match.');
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/password_reset/confirm', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ token, password }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(data.error);
+    }
+    localStorage.removeItem('token');
+    document.getElementById('reset-confirm-section').style.display = 'none';
+    document.getElementById('reset-status').textContent =
+      'Your password was reset. You can now log in with it.';
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
@@ -1,4 +1,5 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  await handleSSORedirect();
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -61,11 +62,15 @@ async function login() {
       },
       body: JSON.stringify({ email, password }),
     });
-    const data = await res.json();
+    let data = await res.json();
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
+    if (data.totp_required) {
+      data = await completeTOTPLogin(data.challenge_token);
+    }
+
     if (data.token) {
       localStorage.setItem('token', data.token);
       document.getElementById('auth-section').style.display = 'none';
@@ -79,6 +84,60 @@ async function login() {
   }
 }
 
+// handleSSORedirect picks up the result of a single sign-on login, which the
+// server passes in the URL fragment.
+async function handleSSORedirect() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  if (!params.has('token') && !params.has('challenge_token') && !params.has('sso_error')) {
+    return;
+  }
+  history.replaceState(null, '', window.location.pathname);
+
+  try {
+    if (params.has('sso_error')) {
+      throw new Error(params.get('sso_error'));
+    }
+    let token = params.get('token');
+    if (params.has('challenge_token')) {
+      const data = await completeTOTPLogin(params.get('challenge_token'));
+      token = data.token;
+    }
+    localStorage.setItem('token', token);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+function loginWithSSO() {
+  window.location.href = '/api/oidc/login';
+}
+
+async function completeTOTPLogin(challengeToken) {
+  const input = prompt('Enter the 6-digit code from your authenticator app, or a recovery code:');
+  if (!input) {
+    throw new Error('Two-factor code is required');
+  }
+  const trimmed = input.trim();
+  const isTOTPCode = /^\d{6}$/.test(trimmed.replace(/\s/g, ''));
+
+  const res = await fetch('/api/login/totp', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({
+      challenge_token: challengeToken,
+      code: isTOTPCode ? trimmed : '',
+      recovery_code: isTOTPCode ? '' : trimmed,
+    }),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.error}`);
+  }
+  return data;
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -264,6 +323,8 @@ function viewVideo(video) {
     // thumbnailImg.src = `${video.thumbnail_url}?v=${Date.now()}`;
     thumbnailImg.src = video.thumbnail_url;
   }
+  thumbnailImg.onmouseenter = video.preview_url ? () => (thumbnailImg.src = video.preview_url) : null;
+  thumbnailImg.onmouseleave = video.preview_url ? () => (thumbnailImg.src = video.thumbnail_url) : null;
 
   const videoPlayer = document.getElementById('video-player');
   if (videoPlayer) {
@@ -272,6 +333,21 @@ function viewVideo(video) {
     } else {
       videoPlayer.style.display = 'block';
       videoPlayer.src = video.video_url;
+      videoPlayer.querySelectorAll('track').forEach((track) => track.remove());
+      for (const caption of video.captions || []) {
+        const track = document.createElement('track');
+        track.kind = 'subtitles';
+        track.label = caption.label;
+        track.srclang = caption.language;
+        track.src = caption.url;
+        videoPlayer.appendChild(track);
+      }
+      if (video.chapters_url) {
+        const track = document.createElement('track');
+        track.kind = 'chapters';
+        track.src = video.chapters_url;
+        videoPlayer.appendChild(track);
+      }
       videoPlayer.load();
     }
   }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+// audioFormat is how an audio-only rendition of a video is encoded.
+type audioFormat struct {
+	Ext         string
+	ContentType string
+	// Args encode the audio and pick the container.
+	Args []string
+}
+
+// audioFormats are the audio-only renditions AUDIO_FORMAT can ask for.
+// Each is a bitrate podcast apps commonly use for speech and music.
+var audioFormats = map[string]audioFormat{
+	"aac":  {Ext: ".m4a", ContentType: "audio/mp4", Args: []string{"-c:a", "aac", "-b:a", "128k", "-f", "ipod"}},
+	"mp3":  {Ext: ".mp3", ContentType: "audio/mpeg", Args: []string{"-c:a", "libmp3lame", "-b:a", "128k", "-f", "mp3"}},
+	"opus": {Ext: ".opus", ContentType: "audio/ogg", Args: []string{"-c:a", "libopus", "-b:a", "96k", "-f", "ogg"}},
+}
+
+// parseAudioFormat reads AUDIO_FORMAT: empty turns audio renditions off.
+func parseAudioFormat(s string) (*audioFormat, error) {
+	if s == "" {
+		return nil, nil
+	}
+	format, ok := audioFormats[s]
+	if !ok {
+		names := make([]string, 0, len(audioFormats))
+		for name := range audioFormats {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("must be one of %v", names)
+	}
+	return &format, nil
+}
+
+// renderAudio writes a video's first audio track in format. The caller
+// must remove the file.
+func renderAudio(ctx context.Context, media ffmpeg.MediaTool, videoPath string, format audioFormat) (string, error) {
+	f, err := os.CreateTemp("", "tubely-audio*"+format.Ext)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	args := []string{"-v", "error", "-y", "-i", videoPath, "-map", "0:a:0", "-vn"}
+	args = append(args, format.Args...)
+	args = append(args, f.Name())
+	_, err = media.Transcode(ctx, args...)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// makeAudio renders and stores a video's audio-only rendition. It goes
+// with the video files rather than the public assets, so private videos'
+// audio needs the same access as their video.
+func (cfg *apiConfig) makeAudio(ctx context.Context, videoID uuid.UUID, videoPath string) (database.Blob, string, error) {
+	audioPath, err := renderAudio(ctx, cfg.media, videoPath, *cfg.audioFormat)
+	if err != nil {
+		return database.Blob{}, "", err
+	}
+	defer os.Remove(audioPath)
+
+	audio, err := hashFile(audioPath)
+	if err != nil {
+		return database.Blob{}, "", err
+	}
+	return cfg.storeVideoBlob(ctx, videoID, audio, "audio/", cfg.audioFormat.Ext, cfg.audioFormat.ContentType)
+}
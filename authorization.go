@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoAction is an operation that changes a video or its assets.
+type videoAction int
+
+const (
+	// videoActionEdit covers metadata changes and asset uploads.
+	videoActionEdit videoAction = iota
+	// videoActionManage covers deleting the video and changing who can edit it.
+	videoActionManage
+)
+
+var (
+	errVideoNotFound  = errors.New("video not found")
+	errVideoForbidden = errors.New("user is not allowed to modify this video")
+	// errInvalidAccessToken covers access tokens that are malformed,
+	// expired, or whose session was revoked.
+	errInvalidAccessToken = errors.New("invalid access token")
+)
+
+// authorizeVideoMutation loads the video and checks that userID may perform
+// action on it. Owners and admins may do anything; collaborators may only edit.
+func (cfg *apiConfig) authorizeVideoMutation(videoID, userID uuid.UUID, action videoAction) (database.Video, error) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, errVideoNotFound
+	}
+
+	if video.UserID == userID {
+		return video, nil
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if user != nil && user.IsAdmin {
+		return video, nil
+	}
+
+	if action == videoActionEdit {
+		isCollaborator, err := cfg.db.IsVideoCollaborator(videoID, userID)
+		if err != nil {
+			return database.Video{}, err
+		}
+		if isCollaborator {
+			return video, nil
+		}
+	}
+
+	return database.Video{}, errVideoForbidden
+}
+
+// videoForMutation authenticates the request, parses the {videoID} path value
+// and authorizes action on it. On failure it writes the error response and
+// returns false, so handlers must not touch the request body or disk before
+// calling it.
+func (cfg *apiConfig) videoForMutation(w http.ResponseWriter, r *http.Request, action videoAction) (database.Video, uuid.UUID, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return database.Video{}, uuid.Nil, false
+	}
+
+	video, err := cfg.authorizeVideoMutation(videoID, userID, action)
+	switch {
+	case errors.Is(err, errVideoNotFound):
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, uuid.Nil, false
+	case errors.Is(err, errVideoForbidden):
+		respondWithError(w, http.StatusForbidden, "You can't modify this video", err)
+		return database.Video{}, uuid.Nil, false
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't authorize video access", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	return video, userID, true
+}
+
+// videoForViewing parses the {videoID} path value and checks that the
+// caller, who may be anonymous, can view the video. A stream token for the
+// video in the query string stands in for an access token, for URLs media
+// elements load. Videos they can't see are reported as missing. On failure
+// it writes the error response and returns false.
+func (cfg *apiConfig) videoForViewing(w http.ResponseWriter, r *http.Request) (database.Video, uuid.UUID, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	viewerID := cfg.optionalUser(r)
+	if streamToken := r.URL.Query().Get("token"); streamToken != "" {
+		userID, tokenVideoID, err := auth.ValidateStreamToken(streamToken, cfg.jwtKeys)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate stream token", err)
+			return database.Video{}, uuid.Nil, false
+		}
+		if tokenVideoID != video.ID {
+			respondWithError(w, http.StatusUnauthorized, "Stream token is for another video", nil)
+			return database.Video{}, uuid.Nil, false
+		}
+		viewerID = userID
+	}
+	canView, err := cfg.canViewVideo(video, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't authorize video access", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if !canView {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	return video, viewerID, true
+}
+
+// validateAccessToken checks an access token and the session it was
+// issued with, returning both IDs. Access tokens outlive a revoked
+// session's refresh token otherwise, so signing out or resetting the
+// password wouldn't end them.
+func (cfg *apiConfig) validateAccessToken(token string) (uuid.UUID, uuid.UUID, error) {
+	userID, sessionID, err := auth.ValidateJWTSession(token, cfg.jwtKeys)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.Join(errInvalidAccessToken, err)
+	}
+	if sessionID == uuid.Nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("%w: no session", errInvalidAccessToken)
+	}
+	active, err := cfg.db.IsSessionActive(userID, sessionID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if !active {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("%w: session revoked or expired", errInvalidAccessToken)
+	}
+	return userID, sessionID, nil
+}
+
+// requireSession authenticates the request's access token, returning the
+// user and the session it belongs to. On failure it writes the error
+// response and returns false.
+func (cfg *apiConfig) requireSession(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	userID, sessionID, err := cfg.validateAccessToken(token)
+	if errors.Is(err, errInvalidAccessToken) {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check session", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, sessionID, true
+}
+
+// requireUser authenticates the request's access token. On failure it
+// writes the error response and returns false.
+func (cfg *apiConfig) requireUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, _, ok := cfg.requireSession(w, r)
+	return userID, ok
+}
+
+// requireAdmin authenticates the request and checks that the caller is an
+// admin. On failure it writes the error response and returns false.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return uuid.Nil, false
+	}
+	if user == nil || !user.IsAdmin {
+		respondWithError(w, http.StatusForbidden, "Admin access required", nil)
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+// canViewVideo reports whether userID may watch video. Public videos are
+// visible to everyone; private ones only to those who may edit them.
+// userID is uuid.Nil for anonymous viewers.
+func (cfg *apiConfig) canViewVideo(video database.Video, userID uuid.UUID) (bool, error) {
+	if !video.IsPrivate {
+		return true, nil
+	}
+	if userID == uuid.Nil {
+		return false, nil
+	}
+	_, err := cfg.authorizeVideoMutation(video.ID, userID, videoActionEdit)
+	if errors.Is(err, errVideoForbidden) || errors.Is(err, errVideoNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// optionalUser returns the caller's user ID, or uuid.Nil when the request
+// carries no valid access token.
+func (cfg *apiConfig) optionalUser(r *http.Request) uuid.UUID {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil
+	}
+	userID, _, err := cfg.validateAccessToken(token)
+	if err != nil {
+		return uuid.Nil
+	}
+	return userID
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// videoRole is how a caller relates to the video a test mutates.
+type videoRole string
+
+const (
+	roleOwner        videoRole = "owner"
+	roleCollaborator videoRole = "collaborator"
+	roleAdmin        videoRole = "admin"
+	roleStranger     videoRole = "stranger"
+)
+
+// mutationHandler is a handler guarded by videoForMutation. newRequest
+// builds a request it would accept from an authorized caller.
+type mutationHandler struct {
+	name       string
+	handler    func(cfg *apiConfig) http.HandlerFunc
+	newRequest func(t *testing.T, videoID string) *http.Request
+	// allowed maps the roles that may call the handler to the status
+	// they get.
+	allowed map[videoRole]int
+}
+
+var mutationHandlers = []mutationHandler{
+	{
+		name:       "upload thumbnail",
+		handler:    func(cfg *apiConfig) http.HandlerFunc { return cfg.handlerUploadThumbnail },
+		newRequest: newThumbnailRequest,
+		allowed: map[videoRole]int{
+			roleOwner:        http.StatusOK,
+			roleCollaborator: http.StatusOK,
+			roleAdmin:        http.StatusOK,
+		},
+	},
+	{
+		name:    "upload video",
+		handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.handlerUploadVideo },
+		// The file isn't a video, so authorized callers get as far as
+		// having it rejected.
+		newRequest: func(t *testing.T, videoID string) *http.Request {
+			return newMultipartRequest(t, "POST", "/api/video_upload/"+videoID, "video", "video.mp4", "video/mp4", []byte("not a video"))
+		},
+		allowed: map[videoRole]int{
+			roleOwner:        http.StatusUnsupportedMediaType,
+			roleCollaborator: http.StatusUnsupportedMediaType,
+			roleAdmin:        http.StatusUnsupportedMediaType,
+		},
+	},
+	{
+		name:    "update metadata",
+		handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.handlerVideoMetaUpdate },
+		newRequest: func(t *testing.T, videoID string) *http.Request {
+			return httptest.NewRequest("PUT", "/api/videos/"+videoID, strings.NewReader(`{"title": "Renamed"}`))
+		},
+		allowed: map[videoRole]int{
+			roleOwner:        http.StatusOK,
+			roleCollaborator: http.StatusOK,
+			roleAdmin:        http.StatusOK,
+		},
+	},
+	{
+		name:    "delete",
+		handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.handlerVideoMetaDelete },
+		newRequest: func(t *testing.T, videoID string) *http.Request {
+			return httptest.NewRequest("DELETE", "/api/videos/"+videoID, nil)
+		},
+		allowed: map[videoRole]int{
+			roleOwner: http.StatusNoContent,
+			roleAdmin: http.StatusNoContent,
+		},
+	},
+}
+
+func TestVideoMutationAuthorization(t *testing.T) {
+	for _, h := range mutationHandlers {
+		for _, role := range []videoRole{roleOwner, roleCollaborator, roleAdmin, roleStranger} {
+			t.Run(h.name+"/"+string(role), func(t *testing.T) {
+				cfg := newTestConfig(t)
+				owner, ownerToken := newTestUser(t, cfg, "owner@example.com")
+				video := newTestVideo(t, cfg, owner)
+
+				token := ownerToken
+				if role != roleOwner {
+					user, userToken := newTestUser(t, cfg, string(role)+"@example.com")
+					token = userToken
+					switch role {
+					case roleCollaborator:
+						err := cfg.db.AddVideoCollaborator(video.ID, user.ID)
+						if err != nil {
+							t.Fatalf("AddVideoCollaborator: %v", err)
+						}
+					case roleAdmin:
+						err := cfg.db.SetUserAdmin(user.ID, true)
+						if err != nil {
+							t.Fatalf("SetUserAdmin: %v", err)
+						}
+					}
+				}
+				assetsBefore, videosBefore := listFiles(t, cfg.assetsRoot), listFiles(t, cfg.videosRoot)
+
+				rr := serveMutation(t, cfg, h, video.ID.String(), token)
+
+				want, ok := h.allowed[role]
+				if !ok {
+					want = http.StatusForbidden
+				}
+				if rr.Code != want {
+					t.Fatalf("status = %d, want %d: %s", rr.Code, want, rr.Body)
+				}
+				if ok {
+					return
+				}
+				assertFilesUnchanged(t, cfg.assetsRoot, assetsBefore)
+				assertFilesUnchanged(t, cfg.videosRoot, videosBefore)
+				stored, err := cfg.db.GetVideo(video.ID)
+				if err != nil {
+					t.Fatalf("GetVideo: %v", err)
+				}
+				if stored.ID != video.ID || stored.Title != video.Title || stored.ThumbnailURL != nil {
+					t.Errorf("rejected request changed the video to %+v", stored)
+				}
+			})
+		}
+	}
+}
+
+func TestVideoMutationMissingVideo(t *testing.T) {
+	for _, h := range mutationHandlers {
+		t.Run(h.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			admin, token := newTestUser(t, cfg, "admin@example.com")
+			err := cfg.db.SetUserAdmin(admin.ID, true)
+			if err != nil {
+				t.Fatalf("SetUserAdmin: %v", err)
+			}
+			assetsBefore, videosBefore := listFiles(t, cfg.assetsRoot), listFiles(t, cfg.videosRoot)
+
+			rr := serveMutation(t, cfg, h, uuid.NewString(), token)
+
+			if rr.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusNotFound, rr.Body)
+			}
+			assertFilesUnchanged(t, cfg.assetsRoot, assetsBefore)
+			assertFilesUnchanged(t, cfg.videosRoot, videosBefore)
+		})
+	}
+}
+
+func TestVideoMutationRevokedSession(t *testing.T) {
+	for _, h := range mutationHandlers {
+		t.Run(h.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			owner, token := newTestUser(t, cfg, "owner@example.com")
+			video := newTestVideo(t, cfg, owner)
+			err := cfg.db.RevokeUserRefreshTokens(owner.ID)
+			if err != nil {
+				t.Fatalf("RevokeUserRefreshTokens: %v", err)
+			}
+
+			rr := serveMutation(t, cfg, h, video.ID.String(), token)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusUnauthorized, rr.Body)
+			}
+		})
+	}
+}
+
+// serveMutation sends h's request for videoID with an access token.
+func serveMutation(t *testing.T, cfg *apiConfig, h mutationHandler, videoID, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := h.newRequest(t, videoID)
+	r.SetPathValue("videoID", videoID)
+	r.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	h.handler(cfg)(rr, r)
+	return rr
+}
+
+func assertFilesUnchanged(t *testing.T, root string, before []string) {
+	t.Helper()
+	after := listFiles(t, root)
+	if !slices.Equal(before, after) {
+		t.Errorf("files under %s changed from %v to %v", root, before, after)
+	}
+}
+
+func newThumbnailRequest(t *testing.T, videoID string) *http.Request {
+	t.Helper()
+	var thumbnail bytes.Buffer
+	err := png.Encode(&thumbnail, image.NewRGBA(image.Rect(0, 0, 16, 9)))
+	if err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return newMultipartRequest(t, "POST", "/api/thumbnail_upload/"+videoID, "thumbnail", "thumbnail.png", "image/png", thumbnail.Bytes())
+}
+
+// newMultipartRequest builds a request uploading contents as a form file.
+func newMultipartRequest(t *testing.T, method, target, field, filename, contentType string, contents []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="`+field+`"; filename="`+filename+`"`)
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	_, err = part.Write(contents)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	err = form.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	r := httptest.NewRequest(method, target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
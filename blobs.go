@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Where a blob's contents live.
+const (
+	// blobStorageAssets is ASSETS_ROOT, served publicly at /assets/.
+	blobStorageAssets = "assets"
+	// blobStorageVideos is the blobs directory under VIDEOS_ROOT, streamed
+	// by the API with access checks.
+	blobStorageVideos = "videos"
+	blobStorageS3     = "s3"
+
+	// localBlobDir is the directory under VIDEOS_ROOT holding video blobs.
+	localBlobDir = "blobs"
+)
+
+const (
+	// blobGCGracePeriod keeps unreferenced blobs around for a while, so a
+	// file someone is re-uploading right now isn't deleted under them.
+	blobGCGracePeriod = time.Hour
+	blobGCBatchSize   = 100
+)
+
+// blobLocks serializes storing and collecting each blob within the server,
+// so garbage collection can't delete contents a new reference has just
+// been taken on.
+type blobLocks struct {
+	mu    sync.Mutex
+	locks map[string]*blobLock
+}
+
+type blobLock struct {
+	mu sync.Mutex
+	// users is how many callers hold or wait for mu.
+	users int
+}
+
+func newBlobLocks() *blobLocks {
+	return &blobLocks{locks: map[string]*blobLock{}}
+}
+
+// lock locks the blob with hash sha in storage, returning the function
+// that unlocks it.
+func (l *blobLocks) lock(storage, sha string) func() {
+	key := storage + "/" + sha
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &blobLock{}
+		l.locks[key] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		l.mu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// hashedFile is a temporary file together with digests of its contents.
+type hashedFile struct {
+	Path   string
+	SHA256 string
+	MD5    []byte
+	Size   int64
+}
+
+// hashToTempFile streams src into a temporary file, hashing it on the way.
+// The caller must remove the file.
+func hashToTempFile(src io.Reader, pattern string) (hashedFile, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return hashedFile{}, err
+	}
+	defer f.Close()
+
+	h, m := sha256.New(), md5.New()
+	size, err := io.Copy(io.MultiWriter(f, h, m), src)
+	if err != nil {
+		os.Remove(f.Name())
+		return hashedFile{}, err
+	}
+	return hashedFile{Path: f.Name(), SHA256: hex.EncodeToString(h.Sum(nil)), MD5: m.Sum(nil), Size: size}, nil
+}
+
+// hashFile hashes a file that is already on disk.
+func hashFile(path string) (hashedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return hashedFile{}, err
+	}
+	defer f.Close()
+
+	h, m := sha256.New(), md5.New()
+	size, err := io.Copy(io.MultiWriter(h, m), f)
+	if err != nil {
+		return hashedFile{}, err
+	}
+	return hashedFile{Path: path, SHA256: hex.EncodeToString(h.Sum(nil)), MD5: m.Sum(nil), Size: size}, nil
+}
+
+// storeBlob adds a reference to the blob holding f's contents, writing them
+// to storage under keyPrefix + hash + ext unless an identical file is
+// already stored. The returned blob's Key is where the contents live.
+func (cfg *apiConfig) storeBlob(ctx context.Context, storage string, f hashedFile, keyPrefix, ext, contentType string) (database.Blob, error) {
+	unlock := cfg.blobLocks.lock(storage, f.SHA256)
+	defer unlock()
+
+	blob, created, err := cfg.db.AcquireBlob(database.AcquireBlobParams{
+		SHA256:      f.SHA256,
+		Size:        f.Size,
+		ContentType: contentType,
+		Storage:     storage,
+		Key:         keyPrefix + f.SHA256 + ext,
+	})
+	if err != nil {
+		return database.Blob{}, err
+	}
+
+	if blob.Stored {
+		return blob, nil
+	}
+	// Either the blob is new or whoever created it hasn't finished, or
+	// failed, writing it. Writes are atomic and of identical contents, so
+	// writing it again is safe either way.
+	err = cfg.writeBlob(ctx, blob, f.Path)
+	if err == nil {
+		err = cfg.db.MarkBlobStored(blob.Storage, blob.SHA256)
+	}
+	if err != nil {
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		if created {
+			// Forget the blob now if nobody else took it up, rather than
+			// leave a row for contents that never made it.
+			_, deleteErr := cfg.db.DeleteBlobIfUnreferenced(blob.Storage, blob.SHA256)
+			if deleteErr != nil {
+				log.Printf("Couldn't forget blob %s: %v", blob.SHA256, deleteErr)
+			}
+		}
+		return database.Blob{}, err
+	}
+	blob.Stored = true
+	return blob, nil
+}
+
+func (cfg *apiConfig) writeBlob(ctx context.Context, blob database.Blob, srcPath string) error {
+	switch blob.Storage {
+	case blobStorageAssets, blobStorageVideos:
+		return copyFileAtomic(srcPath, cfg.localBlobPath(blob), blob.SHA256)
+	case blobStorageS3:
+		src, err := os.Open(srcPath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		sum, err := hex.DecodeString(blob.SHA256)
+		if err != nil {
+			return err
+		}
+		// S3 rejects the upload with BadDigest if what it received doesn't
+		// hash to the checksum we send.
+		checksum := base64.StdEncoding.EncodeToString(sum)
+		out, err := cfg.s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:         aws.String(cfg.s3Bucket),
+			Key:            aws.String(blob.Key),
+			Body:           src,
+			ContentType:    aws.String(blob.ContentType),
+			ChecksumSHA256: aws.String(checksum),
+		})
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "BadDigest" {
+			return fmt.Errorf("%w: s3 object %s: %v", errStorageChecksumMismatch, blob.Key, err)
+		}
+		if err != nil {
+			return err
+		}
+		if out.ChecksumSHA256 != nil && *out.ChecksumSHA256 != checksum {
+			return fmt.Errorf("%w: s3 object %s has sha-256 %s, want %s", errStorageChecksumMismatch, blob.Key, *out.ChecksumSHA256, checksum)
+		}
+		return nil
+	default:
+		return errors.New("unknown blob storage " + blob.Storage)
+	}
+}
+
+func (cfg *apiConfig) localBlobPath(blob database.Blob) string {
+	if blob.Storage == blobStorageAssets {
+		return filepath.Join(cfg.assetsRoot, blob.Key)
+	}
+	return filepath.Join(cfg.videosRoot, localBlobDir, blob.Key)
+}
+
+// copyFileAtomic copies src to dst through a temporary file in dst's
+// directory, so readers never see a partially written blob. The copy must
+// hash to wantSHA256.
+func copyFileAtomic(src, dst, wantSHA256 string) error {
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != wantSHA256 {
+		return fmt.Errorf("%w: copy of %s has sha-256 %s, want %s", errStorageChecksumMismatch, src, got, wantSHA256)
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// releaseBlob drops a reference to the blob with hash sha in storage.
+// Failures are logged: at worst the blob outlives its last user.
+func (cfg *apiConfig) releaseBlob(storage string, sha *string) {
+	if sha == nil || *sha == "" {
+		return
+	}
+	err := cfg.db.ReleaseBlob(storage, *sha)
+	if err != nil {
+		log.Printf("Couldn't release blob %s: %v", *sha, err)
+	}
+}
+
+// collectBlobGarbage deletes blobs that have had no references for longer
+// than the grace period.
+func (cfg *apiConfig) collectBlobGarbage() {
+	blobs, err := cfg.db.GetUnreferencedBlobs(time.Now().UTC().Add(-blobGCGracePeriod), blobGCBatchSize)
+	if err != nil {
+		log.Printf("Couldn't list unreferenced blobs: %v", err)
+		return
+	}
+
+	deleted := 0
+	for _, blob := range blobs {
+		if cfg.collectBlob(blob) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d unreferenced blobs", deleted)
+	}
+}
+
+// collectBlob deletes blob if it's still unreferenced, reporting whether it
+// did. The row and the contents go under the blob's lock, so storeBlob
+// can't take a new reference in between and find its contents removed.
+func (cfg *apiConfig) collectBlob(blob database.Blob) bool {
+	unlock := cfg.blobLocks.lock(blob.Storage, blob.SHA256)
+	defer unlock()
+
+	ok, err := cfg.db.DeleteBlobIfUnreferenced(blob.Storage, blob.SHA256)
+	if err != nil {
+		log.Printf("Couldn't delete blob %s: %v", blob.SHA256, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	err = cfg.deleteBlobContents(blob)
+	if err != nil {
+		log.Printf("Couldn't delete contents of blob %s: %v", blob.SHA256, err)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) deleteBlobContents(blob database.Blob) error {
+	switch blob.Storage {
+	case blobStorageAssets, blobStorageVideos:
+		err := os.Remove(cfg.localBlobPath(blob))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if blob.Storage == blobStorageAssets {
+			cfg.assetFiles.forget(blob.Key)
+		} else {
+			cfg.videoFiles.forget(path.Join(localBlobDir, blob.Key))
+		}
+		return nil
+	case blobStorageS3:
+		_, err := cfg.s3Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+			Bucket: aws.String(cfg.s3Bucket),
+			Key:    aws.String(blob.Key),
+		})
+		return err
+	default:
+		return errors.New("unknown blob storage " + blob.Storage)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// newTestBlobFile writes contents to a temporary file and hashes it.
+func newTestBlobFile(t *testing.T, contents string) hashedFile {
+	t.Helper()
+	path := writeTestFile(t, "blob", contents)
+	f, err := hashFile(path)
+	if err != nil {
+		t.Fatalf("hashFile: %v", err)
+	}
+	return f
+}
+
+func TestStoreBlobWritesUnfinishedBlob(t *testing.T) {
+	cfg := newTestConfig(t)
+	f := newTestBlobFile(t, "contents")
+
+	// Another upload created the blob but never got to write it.
+	_, created, err := cfg.db.AcquireBlob(database.AcquireBlobParams{
+		SHA256:  f.SHA256,
+		Size:    f.Size,
+		Storage: blobStorageAssets,
+		Key:     f.SHA256 + ".txt",
+	})
+	if err != nil || !created {
+		t.Fatalf("AcquireBlob = %t, %v", created, err)
+	}
+
+	blob, err := cfg.storeBlob(context.Background(), blobStorageAssets, f, "", ".txt", "text/plain")
+	if err != nil {
+		t.Fatalf("storeBlob: %v", err)
+	}
+	contents, err := os.ReadFile(filepath.Join(cfg.assetsRoot, blob.Key))
+	if err != nil || string(contents) != "contents" {
+		t.Fatalf("stored %q, %v, want the contents written", contents, err)
+	}
+	stored, err := cfg.db.GetBlob(blobStorageAssets, f.SHA256)
+	if err != nil {
+		t.Fatalf("GetBlob: %v", err)
+	}
+	if !stored.Stored || stored.RefCount != 2 {
+		t.Errorf("blob = %+v, want it stored with both references", stored)
+	}
+}
+
+func TestCollectBlobWaitsForStore(t *testing.T) {
+	cfg := newTestConfig(t)
+	f := newTestBlobFile(t, "contents")
+	blob, err := cfg.storeBlob(context.Background(), blobStorageAssets, f, "", ".txt", "text/plain")
+	if err != nil {
+		t.Fatalf("storeBlob: %v", err)
+	}
+	cfg.releaseBlob(blob.Storage, &blob.SHA256)
+
+	// Collection starts while an upload of the same file holds the blob,
+	// and must see the reference the upload takes.
+	unlock := cfg.blobLocks.lock(blob.Storage, blob.SHA256)
+	collected := make(chan bool)
+	go func() { collected <- cfg.collectBlob(blob) }()
+	_, _, err = cfg.db.AcquireBlob(database.AcquireBlobParams{SHA256: f.SHA256, Storage: blobStorageAssets})
+	if err != nil {
+		t.Fatalf("AcquireBlob: %v", err)
+	}
+	unlock()
+
+	if <-collected {
+		t.Error("collected a blob that was referenced again")
+	}
+	_, err = os.Stat(filepath.Join(cfg.assetsRoot, blob.Key))
+	if err != nil {
+		t.Errorf("contents of a referenced blob are gone: %v", err)
+	}
+}
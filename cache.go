@@ -1,10 +1,205 @@
 package main
 
-import "net/http"
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
 
-func noCacheMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-store")
-		next.ServeHTTP(w, r)
-	})
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// maxAssetDigests bounds how many files' digests an assetServer keeps.
+// Deleted blobs are forgotten as they go; the bound covers files removed
+// some other way that are never asked for again.
+const maxAssetDigests = 10000
+
+// mediaContentTypes covers streaming formats the system MIME tables often
+// get wrong or lack, e.g. .ts is sometimes mapped to TypeScript.
+var mediaContentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".vtt":  "text/vtt; charset=utf-8",
+	".webp": "image/webp",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".opus": "audio/ogg",
+}
+
+// assetServer serves files from the assets directory. Uploaded assets get
+// random names and are never rewritten, so they can be cached forever;
+// anything else must be revalidated with its ETag before reuse.
+type assetServer struct {
+	root http.FileSystem
+
+	mu      sync.Mutex
+	digests map[string]assetDigest
+}
+
+// assetDigest caches a file's SHA-256 until its size or mtime changes.
+type assetDigest struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
+
+func newAssetServer(root string) *assetServer {
+	return &assetServer{
+		root:    http.Dir(root),
+		digests: map[string]assetDigest{},
+	}
+}
+
+func (s *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	s.serveFile(w, r, r.URL.Path, "")
+}
+
+// serveFile serves name relative to the server's root. cacheControl
+// overrides the policy picked from the file name when it isn't empty.
+func (s *assetServer) serveFile(w http.ResponseWriter, r *http.Request, name, cacheControl string) {
+	name = path.Clean("/" + name)
+	f, err := s.root.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.forget(name)
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open asset", err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't stat asset", err)
+		return
+	}
+	if info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	sum, err := s.digest(name, f, info)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash asset", err)
+		return
+	}
+
+	if cacheControl == "" {
+		cacheControl = "no-cache"
+		if isImmutableAssetName(name) {
+			cacheControl = immutableCacheControl
+		}
+	}
+	w.Header().Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(sum)+`"`)
+	// Lets clients check the integrity of what they downloaded (RFC 9530).
+	w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+	w.Header().Set("Cache-Control", cacheControl)
+	if contentType, ok := mediaContentTypes[path.Ext(name)]; ok {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	// ServeContent handles If-None-Match, If-Modified-Since, If-Range and
+	// byte ranges using the ETag and modtime set above.
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// digest returns the SHA-256 of the file's contents. Blobs are named after
+// it, so only other files are read, and then only when they haven't been
+// seen at their current size and mtime. Strong ETags are derived from it.
+func (s *assetServer) digest(name string, f http.File, info fs.FileInfo) ([]byte, error) {
+	if sum, ok := contentAddressedDigest(name); ok {
+		return sum, nil
+	}
+
+	s.mu.Lock()
+	cached, ok := s.digests[name]
+	s.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	h := sha256.New()
+	_, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	sum := h.Sum(nil)
+
+	s.mu.Lock()
+	if _, ok := s.digests[name]; !ok && len(s.digests) >= maxAssetDigests {
+		// Any entry will do: a dropped digest is just hashed again.
+		for other := range s.digests {
+			delete(s.digests, other)
+			break
+		}
+	}
+	s.digests[name] = assetDigest{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	s.mu.Unlock()
+	return sum, nil
+}
+
+// forget drops the cached digest of name, relative to the server's root,
+// once the file is gone.
+func (s *assetServer) forget(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.digests, path.Clean("/"+name))
+	s.mu.Unlock()
+}
+
+// contentAddressedDigest returns the SHA-256 a blob's name was derived
+// from. Blobs are checked against it when written, see copyFileAtomic.
+func contentAddressedDigest(name string) ([]byte, bool) {
+	base := path.Base(name)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	if len(base) != hex.EncodedLen(sha256.Size) {
+		return nil, false
+	}
+	sum, err := hex.DecodeString(base)
+	if err != nil {
+		return nil, false
+	}
+	return sum, true
+}
+
+// isImmutableAssetName reports whether name looks like one of the names
+// uploads are stored under: the hex SHA-256 of the contents, or 32 random
+// bytes for older uploads. Those are never overwritten, so a cached copy
+// can't go stale.
+func isImmutableAssetName(name string) bool {
+	base := path.Base(name)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	if _, ok := contentAddressedDigest(name); ok {
+		return true
+	}
+	if len(base) != base64.RawURLEncoding.EncodedLen(32) {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(base)
+	return err == nil
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// cdnFlushDelay lets invalidations requested close together go out in
+	// one batch; CloudFront bills and rate limits per batch.
+	cdnFlushDelay = 2 * time.Second
+	// cdnRetryInterval is how often due retries are picked up when nothing
+	// new was requested.
+	cdnRetryInterval  = 30 * time.Second
+	cdnBatchSize      = 100
+	cdnMaxAttempts    = 8
+	cdnRetryBackoff   = 30 * time.Second
+	cdnMaxBackoff     = time.Hour
+	cdnRequestTimeout = 30 * time.Second
+)
+
+// videoURLs returns every URL a video and its caption tracks point at.
+// Storyboard sheets are only listed in the storyboard index, so their URLs
+// are worked out from their blobs.
+func (cfg *apiConfig) videoURLs(video database.Video, videoCaptions []database.VideoCaption, sheetSHA256s []string) []*string {
+	urls := []*string{
+		video.VideoURL,
+		video.ThumbnailURL,
+		video.SourceURL,
+		video.AudioURL,
+		video.PreviewURL,
+		video.PreviewMP4URL,
+		video.StoryboardURL,
+	}
+	for _, caption := range videoCaptions {
+		urls = append(urls, &caption.URL)
+	}
+	if storage := cfg.renditionStorage(video.StoryboardURL); storage != blobStorageAssets {
+		for _, sha := range sheetSHA256s {
+			blob, err := cfg.db.GetBlob(storage, sha)
+			if err != nil {
+				log.Printf("Couldn't get storyboard sheet %s: %v", sha, err)
+				continue
+			}
+			if blob.Key == "" {
+				continue
+			}
+			sheetURL := cfg.videoBlobURL(video.ID, blob)
+			urls = append(urls, &sheetURL)
+		}
+	}
+	return urls
+}
+
+// cdnPaths maps asset URLs to the paths the CDN caches them under. URLs
+// that aren't served through the CDN are skipped.
+func (cfg *apiConfig) cdnPaths(urls ...*string) []string {
+	paths := []string{}
+	for _, rawURL := range urls {
+		if rawURL == nil || *rawURL == "" {
+			continue
+		}
+		u, err := url.Parse(*rawURL)
+		if err != nil {
+			continue
+		}
+
+		var cdnPath string
+		switch {
+		case cfg.edge != nil && strings.HasPrefix(*rawURL, cfg.publicURL+"/edge/"):
+			cdnPath = strings.TrimPrefix(u.Path, "/edge")
+		case cfg.s3CfDistribution != "" && u.Host == cfg.s3CfDistribution:
+			cdnPath = u.Path
+		default:
+			continue
+		}
+
+		// A locally stored video may be an HLS playlist with segments,
+		// so drop everything stored for it.
+		if dir, _, ok := strings.Cut(cdnPath, "/stream/"); ok && strings.HasPrefix(dir, "/api/videos/") {
+			cdnPath = dir + "/stream/*"
+		}
+		paths = append(paths, cdnPath)
+	}
+	return paths
+}
+
+// invalidateCDN records that cached copies of urls must be dropped and
+// wakes the worker that submits them. Failures are logged rather than
+// returned: the asset change itself has already succeeded.
+func (cfg *apiConfig) invalidateCDN(reason string, urls ...*string) {
+	paths := cfg.cdnPaths(urls...)
+	if len(paths) == 0 {
+		return
+	}
+	_, err := cfg.db.CreateCDNInvalidation(reason, paths)
+	if err != nil {
+		log.Printf("Couldn't record CDN invalidation for %v: %v", paths, err)
+		return
+	}
+	cfg.wakeCDNInvalidations()
+}
+
+// wakeCDNInvalidations tells the worker there is something to submit.
+func (cfg *apiConfig) wakeCDNInvalidations() {
+	select {
+	case cfg.cdnWake <- struct{}{}:
+	default:
+	}
+}
+
+// runCDNInvalidations submits pending invalidations in batches until the
+// process exits, retrying failed batches with backoff.
+func (cfg *apiConfig) runCDNInvalidations() {
+	ticker := time.NewTicker(cdnRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cfg.cdnWake:
+			time.Sleep(cdnFlushDelay)
+		case <-ticker.C:
+		}
+		for cfg.flushCDNInvalidations() {
+		}
+	}
+}
+
+// flushCDNInvalidations submits one batch of due invalidations and
+// reports whether a full batch was sent, i.e. more may be waiting.
+func (cfg *apiConfig) flushCDNInvalidations() bool {
+	due, err := cfg.db.GetDueCDNInvalidations(time.Now().UTC(), cdnBatchSize)
+	if err != nil {
+		log.Printf("Couldn't load pending CDN invalidations: %v", err)
+		return false
+	}
+	if len(due) == 0 {
+		return false
+	}
+
+	ids := make([]uuid.UUID, 0, len(due))
+	paths := []string{}
+	seen := map[string]bool{}
+	for _, invalidation := range due {
+		ids = append(ids, invalidation.ID)
+		for _, p := range invalidation.Paths {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cdnRequestTimeout)
+	defer cancel()
+	ref, err := cfg.cdnInvalidator.Invalidate(ctx, cdnBatchReference(ids), paths)
+	if err != nil {
+		log.Printf("Couldn't invalidate %d CDN paths: %v", len(paths), err)
+		cfg.recordCDNFailures(due, err)
+		return false
+	}
+
+	err = cfg.db.MarkCDNInvalidationsSubmitted(ids, ref)
+	if err != nil {
+		log.Printf("Couldn't mark CDN invalidations submitted: %v", err)
+		return false
+	}
+	return len(due) == cdnBatchSize
+}
+
+func (cfg *apiConfig) recordCDNFailures(due []database.CDNInvalidation, cause error) {
+	for _, invalidation := range due {
+		attempts := invalidation.Attempts + 1
+		var next *time.Time
+		if attempts < cdnMaxAttempts {
+			backoff := cdnRetryBackoff << (attempts - 1)
+			if backoff > cdnMaxBackoff || backoff <= 0 {
+				backoff = cdnMaxBackoff
+			}
+			t := time.Now().UTC().Add(backoff)
+			next = &t
+		}
+		err := cfg.db.RecordCDNInvalidationFailure(invalidation.ID, cause.Error(), next)
+		if err != nil {
+			log.Printf("Couldn't record CDN invalidation failure for %s: %v", invalidation.ID, err)
+		}
+	}
+}
+
+// cdnBatchReference derives a stable caller reference from the batched
+// invalidation IDs, so resubmitting the same batch doesn't create a
+// duplicate invalidation.
+func cdnBatchReference(ids []uuid.UUID) string {
+	h := sha256.New()
+	for _, id := range ids {
+		h.Write(id[:])
+	}
+	return "tubely-" + hex.EncodeToString(h.Sum(nil))[:32]
+}
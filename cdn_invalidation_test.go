@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestVideoURLsIncludesCaptionsAndSheets(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, _ := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, user)
+
+	sheet, sheetURL, err := cfg.storeVideoBlob(context.Background(), video.ID, newTestBlobFile(t, "sheet"), "storyboard/", ".jpg", "image/jpeg")
+	if err != nil {
+		t.Fatalf("storeVideoBlob: %v", err)
+	}
+	indexURL := cfg.localStreamURL(video.ID, "index.vtt")
+	video.StoryboardURL = &indexURL
+	captionURL := cfg.localStreamURL(video.ID, "captions.vtt")
+	videoCaptions := []database.VideoCaption{{VideoID: video.ID, Language: "en", URL: captionURL}}
+
+	urls := []string{}
+	for _, u := range cfg.videoURLs(video, videoCaptions, []string{sheet.SHA256}) {
+		if u != nil {
+			urls = append(urls, *u)
+		}
+	}
+	for _, want := range []string{indexURL, captionURL, sheetURL} {
+		if !slices.Contains(urls, want) {
+			t.Errorf("videoURLs = %v, missing %s", urls, want)
+		}
+	}
+}
+
+func TestMadePrivateInvalidatesAudioAndCaptions(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.s3CfDistribution = "cdn.tubely.test"
+	owner, token := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, owner)
+	audioURL := "https://cdn.tubely.test/audio/a.m4a"
+	video.AudioURL = &audioURL
+	err := cfg.db.UpdateVideo(video, nil)
+	if err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	_, err = cfg.db.UpsertVideoCaption(database.UpsertVideoCaptionParams{
+		VideoID:  video.ID,
+		Language: "en",
+		Label:    "English",
+		URL:      "https://cdn.tubely.test/captions/c.vtt",
+		SHA256:   "c",
+		Storage:  blobStorageS3,
+	})
+	if err != nil {
+		t.Fatalf("UpsertVideoCaption: %v", err)
+	}
+
+	h := mutationHandler{
+		handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.handlerVideoMetaUpdate },
+		newRequest: func(t *testing.T, videoID string) *http.Request {
+			return httptest.NewRequest("PUT", "/api/videos/"+videoID, strings.NewReader(`{"is_private": true}`))
+		},
+	}
+	rr := serveMutation(t, cfg, h, video.ID.String(), token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	invalidations, err := cfg.db.GetCDNInvalidations(10)
+	if err != nil {
+		t.Fatalf("GetCDNInvalidations: %v", err)
+	}
+	paths := []string{}
+	for _, invalidation := range invalidations {
+		paths = append(paths, invalidation.Paths...)
+	}
+	for _, want := range []string{"/audio/a.m4a", "/captions/c.vtt"} {
+		if !slices.Contains(paths, want) {
+			t.Errorf("invalidated %v, missing %s", paths, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// Error codes returned alongside upload integrity failures.
+const (
+	errCodeInvalidChecksum         = "invalid_checksum"
+	errCodeChecksumMismatch        = "checksum_mismatch"
+	errCodeStorageChecksumMismatch = "storage_checksum_mismatch"
+)
+
+var (
+	// errChecksumMismatch means the uploaded bytes don't match the checksum
+	// the client sent with them.
+	errChecksumMismatch = errors.New("checksum mismatch")
+	// errStorageChecksumMismatch means storage didn't end up holding the
+	// bytes we handed it.
+	errStorageChecksumMismatch = errors.New("stored contents don't match checksum")
+)
+
+const (
+	headerContentMD5     = "Content-MD5"
+	headerChecksumSHA256 = "X-Checksum-Sha256"
+	// Form fields standing in for the part headers, which browsers can't
+	// set.
+	fieldContentMD5     = "content_md5"
+	fieldChecksumSHA256 = "checksum_sha256"
+)
+
+// uploadChecksums are the digests a client expects an uploaded file to
+// have. Either may be nil when the client didn't send it.
+type uploadChecksums struct {
+	MD5    []byte
+	SHA256 []byte
+}
+
+// parseUploadChecksums reads the optional Content-MD5 (base64, RFC 1864) and
+// X-Checksum-Sha256 (hex or base64) headers of an uploaded file's multipart
+// part, or failing those the content_md5 and checksum_sha256 form fields.
+// Headers on the request itself are ignored: a request's Content-MD5 is
+// the digest of the whole multipart body, not of the file.
+func parseUploadChecksums(part textproto.MIMEHeader, r *http.Request) (uploadChecksums, error) {
+	get := func(header, field string) string {
+		if v := part.Get(header); v != "" {
+			return v
+		}
+		return r.FormValue(field)
+	}
+
+	var checksums uploadChecksums
+	if v := get(headerContentMD5, fieldContentMD5); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(sum) != 16 {
+			return uploadChecksums{}, fmt.Errorf("%s must be a base64 MD5 digest", headerContentMD5)
+		}
+		checksums.MD5 = sum
+	}
+	if v := get(headerChecksumSHA256, fieldChecksumSHA256); v != "" {
+		sum, err := decodeSHA256(strings.TrimSpace(v))
+		if err != nil {
+			return uploadChecksums{}, fmt.Errorf("%s must be a hex or base64 SHA-256 digest", headerChecksumSHA256)
+		}
+		checksums.SHA256 = sum
+	}
+	return checksums, nil
+}
+
+func decodeSHA256(s string) ([]byte, error) {
+	var sum []byte
+	var err error
+	if len(s) == hex.EncodedLen(32) {
+		sum, err = hex.DecodeString(s)
+	} else {
+		sum, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(sum) != 32 {
+		return nil, errors.New("wrong digest length")
+	}
+	return sum, nil
+}
+
+// verify checks the received file against the expected checksums.
+func (c uploadChecksums) verify(f hashedFile) error {
+	if c.MD5 != nil && !bytes.Equal(c.MD5, f.MD5) {
+		return fmt.Errorf("%w: %s is %s, received %s", errChecksumMismatch, headerContentMD5,
+			base64.StdEncoding.EncodeToString(c.MD5), base64.StdEncoding.EncodeToString(f.MD5))
+	}
+	if c.SHA256 != nil && hex.EncodeToString(c.SHA256) != f.SHA256 {
+		return fmt.Errorf("%w: %s is %s, received %s", errChecksumMismatch, headerChecksumSHA256,
+			hex.EncodeToString(c.SHA256), f.SHA256)
+	}
+	return nil
+}
+
+// receiveUpload streams an uploaded file to a temporary file, verifying it
+// against the checksums the client sent. On failure it has already
+// responded and removed the file.
+func receiveUpload(w http.ResponseWriter, r *http.Request, part textproto.MIMEHeader, src io.Reader, pattern string) (hashedFile, bool) {
+	checksums, err := parseUploadChecksums(part, r)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidChecksum, err.Error(), err)
+		return hashedFile{}, false
+	}
+
+	upload, err := hashToTempFile(src, pattern)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to copy file", err)
+		return hashedFile{}, false
+	}
+
+	err = checksums.verify(upload)
+	if err != nil {
+		os.Remove(upload.Path)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeChecksumMismatch, "Uploaded file doesn't match its checksum", err)
+		return hashedFile{}, false
+	}
+	return upload, true
+}
+
+// respondWithStoreError reports a failure to store a blob, flagging
+// contents that storage corrupted.
+func respondWithStoreError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, errStorageChecksumMismatch) {
+		respondWithErrorCode(w, http.StatusBadGateway, errCodeStorageChecksumMismatch, "Stored file doesn't match its checksum", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, msg, err)
+}
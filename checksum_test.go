@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+func TestParseUploadChecksums(t *testing.T) {
+	fileMD5 := md5.Sum([]byte("file"))
+	encoded := base64.StdEncoding.EncodeToString(fileMD5[:])
+	tests := []struct {
+		name          string
+		partHeader    string
+		field         string
+		requestHeader string
+		want          []byte
+	}{
+		{name: "part header", partHeader: encoded, want: fileMD5[:]},
+		{name: "form field", field: encoded, want: fileMD5[:]},
+		// A request's Content-MD5 is the digest of the whole body.
+		{name: "request header", requestHeader: encoded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			if tt.field != "" {
+				err := form.WriteField(fieldContentMD5, tt.field)
+				if err != nil {
+					t.Fatalf("WriteField: %v", err)
+				}
+			}
+			err := form.Close()
+			if err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			r := httptest.NewRequest("POST", "/upload", &body)
+			r.Header.Set("Content-Type", form.FormDataContentType())
+			if tt.requestHeader != "" {
+				r.Header.Set(headerContentMD5, tt.requestHeader)
+			}
+			part := textproto.MIMEHeader{}
+			if tt.partHeader != "" {
+				part.Set(headerContentMD5, tt.partHeader)
+			}
+
+			checksums, err := parseUploadChecksums(part, r)
+			if err != nil {
+				t.Fatalf("parseUploadChecksums: %v", err)
+			}
+			if !bytes.Equal(checksums.MD5, tt.want) {
+				t.Errorf("MD5 = %x, want %x", checksums.MD5, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	cleanupInterval = time.Hour
+	// Revoked and expired sessions are kept this long so recent sign-outs
+	// can still be inspected, then deleted.
+	staleSessionRetention = 7 * 24 * time.Hour
+)
+
+// runCleanup periodically deletes expired credentials so the token tables
+// don't grow without bound, and unreferenced blobs.
+func (cfg *apiConfig) runCleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		cfg.cleanupExpired()
+		<-ticker.C
+	}
+}
+
+func (cfg *apiConfig) cleanupExpired() {
+	cutoff := time.Now().UTC().Add(-staleSessionRetention)
+
+	n, err := cfg.db.DeleteStaleRefreshTokens(cutoff)
+	if err != nil {
+		log.Printf("Couldn't delete stale refresh tokens: %v", err)
+	} else if n > 0 {
+		log.Printf("Deleted %d stale refresh tokens", n)
+	}
+
+	n, err = cfg.db.DeleteStaleAccountTokens(time.Now().UTC())
+	if err != nil {
+		log.Printf("Couldn't delete expired account tokens: %v", err)
+	} else if n > 0 {
+		log.Printf("Deleted %d expired account tokens", n)
+	}
+
+	cfg.collectBlobGarbage()
+}
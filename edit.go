@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+const (
+	errCodeInvalidEdit = "invalid_edit"
+
+	maxEditSegments = 50
+	// minEditSegment keeps segments to at least a few frames.
+	minEditSegment = 100 * time.Millisecond
+	// keyframeTolerance absorbs the rounding in times ffprobe reports.
+	keyframeTolerance = time.Millisecond
+)
+
+// editSegment is a stretch of a video to keep, from Start up to End.
+type editSegment struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// validateEdit checks segments against the length of the video they are
+// cut from, returning an error suitable for the client. Segments may be
+// in any order and may overlap: they play back to back as given.
+func validateEdit(segments []editSegment, duration time.Duration) error {
+	if len(segments) == 0 {
+		return errors.New("an edit needs at least one segment")
+	}
+	if len(segments) > maxEditSegments {
+		return fmt.Errorf("an edit can have at most %d segments", maxEditSegments)
+	}
+	var total time.Duration
+	for i, segment := range segments {
+		if segment.Start < 0 || segment.End > duration {
+			return fmt.Errorf("segment %d must be within the video's %d ms", i+1, duration.Milliseconds())
+		}
+		if segment.End-segment.Start < minEditSegment {
+			return fmt.Errorf("segment %d must end at least %d ms after it starts", i+1, minEditSegment.Milliseconds())
+		}
+		total += segment.End - segment.Start
+	}
+	if total > maxVideoDuration*time.Second {
+		return fmt.Errorf("the edited video would be longer than %d seconds", maxVideoDuration)
+	}
+	return nil
+}
+
+// startsOnKeyframes reports whether every segment starts on a keyframe,
+// so it can be cut without re-encoding.
+func startsOnKeyframes(segments []editSegment, keyframes []time.Duration) bool {
+	for _, segment := range segments {
+		found := false
+		for _, keyframe := range keyframes {
+			if (keyframe - segment.Start).Abs() <= keyframeTolerance {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// cutVideo writes the segments of a video back to back into a new MP4.
+// Streams are copied when every segment starts on a keyframe; otherwise
+// the video is re-encoded so cuts land exactly. The caller must remove
+// the new file.
+func cutVideo(ctx context.Context, media ffmpeg.MediaTool, path string, segments []editSegment, probe probedVideo) (string, error) {
+	f, err := os.CreateTemp("", "tubely-edit*.mp4")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	keyframes, err := media.Keyframes(ctx, path)
+	if err == nil && startsOnKeyframes(segments, keyframes) {
+		err = cutVideoCopy(ctx, media, path, f.Name(), segments)
+	} else {
+		err = cutVideoReencode(ctx, media, path, f.Name(), segments, probe.Audio != nil)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// cutVideoCopy joins the segments with the concat demuxer, copying the
+// streams.
+func cutVideoCopy(ctx context.Context, media ffmpeg.MediaTool, path, outputPath string, segments []editSegment) error {
+	list, err := os.CreateTemp("", "tubely-edit*.ffconcat")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+
+	quoted := "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+	var script strings.Builder
+	script.WriteString("ffconcat version 1.0\n")
+	for _, segment := range segments {
+		fmt.Fprintf(&script, "file %s\ninpoint %.3f\noutpoint %.3f\n", quoted, segment.Start.Seconds(), segment.End.Seconds())
+	}
+	_, err = list.WriteString(script.String())
+	if closeErr := list.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = media.Transcode(ctx, "-v", "error", "-y", "-f", "concat", "-safe", "0", "-i", list.Name(),
+		"-map", "0:v:0", "-map", "0:a:0?", "-c", "copy", "-f", "mp4", outputPath)
+	return err
+}
+
+// cutVideoReencode trims the segments out with filters and joins them,
+// re-encoding to H.264 and AAC.
+func cutVideoReencode(ctx context.Context, media ffmpeg.MediaTool, path, outputPath string, segments []editSegment, hasAudio bool) error {
+	filters := []string{}
+	labels := ""
+	for i, segment := range segments {
+		start, end := fmt.Sprintf("%.3f", segment.Start.Seconds()), fmt.Sprintf("%.3f", segment.End.Seconds())
+		filters = append(filters, fmt.Sprintf("[0:v:0]trim=start=%s:end=%s,setpts=PTS-STARTPTS[v%d]", start, end, i))
+		labels += fmt.Sprintf("[v%d]", i)
+		if hasAudio {
+			filters = append(filters, fmt.Sprintf("[0:a:0]atrim=start=%s:end=%s,asetpts=PTS-STARTPTS[a%d]", start, end, i))
+			labels += fmt.Sprintf("[a%d]", i)
+		}
+	}
+	if hasAudio {
+		filters = append(filters, fmt.Sprintf("%sconcat=n=%d:v=1:a=1[v][a]", labels, len(segments)))
+	} else {
+		filters = append(filters, fmt.Sprintf("%sconcat=n=%d:v=1:a=0[v]", labels, len(segments)))
+	}
+
+	args := []string{"-v", "error", "-y", "-i", path, "-filter_complex", strings.Join(filters, ";"), "-map", "[v]"}
+	args = append(args, h264EncodeArgs...)
+	if hasAudio {
+		args = append(args, "-map", "[a]")
+		args = append(args, aacEncodeArgs...)
+	}
+	args = append(args, "-f", "mp4", outputPath)
+	_, err := media.Transcode(ctx, args...)
+	return err
+}
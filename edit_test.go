@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+func TestCutVideo(t *testing.T) {
+	segments := []editSegment{
+		{Start: 2 * time.Second, End: 5 * time.Second},
+		{Start: 10 * time.Second, End: 12 * time.Second},
+	}
+	tests := []struct {
+		name      string
+		keyframes []time.Duration
+		hasAudio  bool
+		wantCopy  bool
+	}{
+		{
+			name:      "segments start on keyframes",
+			keyframes: []time.Duration{0, 2 * time.Second, 10*time.Second + 500*time.Microsecond},
+			hasAudio:  true,
+			wantCopy:  true,
+		},
+		{
+			name:      "segment starts between keyframes",
+			keyframes: []time.Duration{0, 2 * time.Second, 8 * time.Second},
+			hasAudio:  true,
+		},
+		{
+			name:      "silent video between keyframes",
+			keyframes: []time.Duration{0, 4 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			media := &ffmpeg.Fake{KeyframeTimes: tt.keyframes}
+			probe := probedVideo{Video: testH264Stream, Duration: 20 * time.Second}
+			if tt.hasAudio {
+				probe.Audio = &testAACStream
+			}
+
+			edited, err := cutVideo(context.Background(), media, "video.mp4", segments, probe)
+			if err != nil {
+				t.Fatalf("cutVideo: %v", err)
+			}
+			defer os.Remove(edited)
+
+			calls := media.Calls()
+			if len(calls) != 2 || calls[0].Method != "Keyframes" || calls[1].Method != "Transcode" {
+				t.Fatalf("calls = %+v, want keyframes read then a transcode", calls)
+			}
+			args := calls[1].Args
+			if args[len(args)-1] != edited {
+				t.Errorf("wrote %s, want %s", args[len(args)-1], edited)
+			}
+
+			copied := slices.Contains(args, "concat") && slices.Contains(args, "copy")
+			reencoded := slices.Contains(args, "-filter_complex")
+			if copied != tt.wantCopy || reencoded == tt.wantCopy {
+				t.Fatalf("cut with %q, want copy = %t", args, tt.wantCopy)
+			}
+			if tt.wantCopy {
+				return
+			}
+
+			filters := args[slices.Index(args, "-filter_complex")+1]
+			for _, want := range []string{"trim=start=2.000:end=5.000", "trim=start=10.000:end=12.000"} {
+				if !strings.Contains(filters, want) {
+					t.Errorf("filters %q lack %s", filters, want)
+				}
+			}
+			if strings.Contains(filters, "atrim") != tt.hasAudio || slices.Contains(args, "[a]") != tt.hasAudio {
+				t.Errorf("filters %q mishandle audio, want audio = %t", filters, tt.hasAudio)
+			}
+		})
+	}
+}
+
+func TestCutVideoReencodesWithoutKeyframes(t *testing.T) {
+	media := &ffmpeg.Fake{}
+	segments := []editSegment{{Start: 0, End: time.Second}}
+
+	edited, err := cutVideo(context.Background(), media, "video.mp4", segments, probedVideo{Video: testH264Stream})
+	if err != nil {
+		t.Fatalf("cutVideo: %v", err)
+	}
+	defer os.Remove(edited)
+
+	calls := media.Calls()
+	if len(calls) != 2 || !slices.Contains(calls[1].Args, "-filter_complex") {
+		t.Errorf("calls = %+v, want a re-encode when no keyframes are known", calls)
+	}
+}
+
+// newPublishedTestVideo creates a video whose published file holds
+// contents, processing media with a fake that probes it as silent H.264.
+func newPublishedTestVideo(t *testing.T, cfg *apiConfig, contents string) database.Video {
+	t.Helper()
+	cfg.media = &ffmpeg.Fake{ProbeResult: ffmpeg.ProbeResult{
+		Streams: []ffmpeg.Stream{testH264Stream},
+		Format:  ffmpeg.Format{FormatName: "mov,mp4", Duration: "20.000"},
+	}}
+	user, _ := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, user)
+	setTestVideoFile(t, cfg, &video, contents)
+	return video
+}
+
+// setTestVideoFile stores contents as video's published file, as an upload
+// that replaced it would.
+func setTestVideoFile(t *testing.T, cfg *apiConfig, video *database.Video, contents string) {
+	t.Helper()
+	f, err := hashFile(writeTestFile(t, "video.mp4", contents))
+	if err != nil {
+		t.Fatalf("hashFile: %v", err)
+	}
+	blob, videoURL, err := cfg.storeVideoBlob(context.Background(), video.ID, f, "", ".mp4", "video/mp4")
+	if err != nil {
+		t.Fatalf("storeVideoBlob: %v", err)
+	}
+	oldSHA256 := video.VideoSHA256
+	video.VideoURL, video.VideoSHA256 = &videoURL, &blob.SHA256
+	err = cfg.db.UpdateVideo(*video, oldSHA256)
+	if err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+}
+
+// assertBlobRefs checks how many references the video blob with hash sha
+// has.
+func assertBlobRefs(t *testing.T, cfg *apiConfig, sha *string, want int) {
+	t.Helper()
+	blob, err := cfg.db.GetBlob(cfg.videoBlobStorage(), *sha)
+	if err != nil {
+		t.Fatalf("GetBlob: %v", err)
+	}
+	if blob.RefCount != want {
+		t.Errorf("blob %s has %d references, want %d", *sha, blob.RefCount, want)
+	}
+}
+
+func TestPublishRenditionRejectsReplacedVideo(t *testing.T) {
+	cfg := newTestConfig(t)
+	stale := newPublishedTestVideo(t, cfg, "first upload")
+	current := stale
+	setTestVideoFile(t, cfg, &current, "second upload")
+
+	_, err := cfg.publishRendition(context.Background(), stale, writeTestFile(t, "edited.mp4", "edited"), stale.VideoSHA256, nil)
+	if !errors.Is(err, database.ErrVideoChanged) {
+		t.Fatalf("publishRendition = %v, want ErrVideoChanged", err)
+	}
+
+	stored, err := cfg.db.GetVideo(current.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if *stored.VideoSHA256 != *current.VideoSHA256 || stored.Edited {
+		t.Errorf("video = %+v, want the second upload kept", stored)
+	}
+	// The stale edit must not give up references the second upload holds.
+	assertBlobRefs(t, cfg, current.VideoSHA256, 1)
+}
+
+func TestPublishRenditionDropsAutoCaptions(t *testing.T) {
+	cfg := newTestConfig(t)
+	video := newPublishedTestVideo(t, cfg, "upload")
+	blobs := map[string]database.Blob{}
+	for _, caption := range []struct {
+		language      string
+		autoGenerated bool
+	}{{"en", true}, {"fr", false}} {
+		blob, captionURL, err := cfg.storeCaptionBlob(context.Background(), video.ID, newTestBlobFile(t, "WEBVTT\n\n"+caption.language))
+		if err != nil {
+			t.Fatalf("storeCaptionBlob: %v", err)
+		}
+		_, err = cfg.db.UpsertVideoCaption(database.UpsertVideoCaptionParams{
+			VideoID:       video.ID,
+			Language:      caption.language,
+			Label:         caption.language,
+			URL:           captionURL,
+			SHA256:        blob.SHA256,
+			Storage:       blob.Storage,
+			AutoGenerated: caption.autoGenerated,
+		})
+		if err != nil {
+			t.Fatalf("UpsertVideoCaption: %v", err)
+		}
+		blobs[caption.language] = blob
+	}
+
+	_, err := cfg.publishRendition(context.Background(), video, writeTestFile(t, "edited.mp4", "edited"), video.VideoSHA256, nil)
+	if err != nil {
+		t.Fatalf("publishRendition: %v", err)
+	}
+
+	videoCaptions, err := cfg.db.GetVideoCaptions(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideoCaptions: %v", err)
+	}
+	if len(videoCaptions) != 1 || videoCaptions[0].Language != "fr" {
+		t.Errorf("captions = %+v, want only the uploaded fr track", videoCaptions)
+	}
+	en, fr := blobs["en"], blobs["fr"]
+	assertBlobRefs(t, cfg, &en.SHA256, 0)
+	assertBlobRefs(t, cfg, &fr.SHA256, 1)
+}
+
+func TestPublishRenditionMovesUneditedReference(t *testing.T) {
+	cfg := newTestConfig(t)
+	video := newPublishedTestVideo(t, cfg, "upload")
+	uploaded := *video.VideoSHA256
+	segments := []database.VideoEditSegment{{StartMS: 0, EndMS: 5000}}
+
+	// A first edit keeps the upload as the unedited file.
+	first, err := cfg.publishRendition(context.Background(), video, writeTestFile(t, "first.mp4", "first edit"), &uploaded, segments)
+	if err != nil {
+		t.Fatalf("publishRendition: %v", err)
+	}
+	assertBlobRefs(t, cfg, &uploaded, 1)
+	assertBlobRefs(t, cfg, first.VideoSHA256, 1)
+
+	// Later edits replace the edit but leave the unedited file alone.
+	second, err := cfg.publishRendition(context.Background(), first, writeTestFile(t, "second.mp4", "second edit"), first.UneditedSHA256, segments)
+	if err != nil {
+		t.Fatalf("publishRendition: %v", err)
+	}
+	assertBlobRefs(t, cfg, &uploaded, 1)
+	assertBlobRefs(t, cfg, first.VideoSHA256, 0)
+	assertBlobRefs(t, cfg, second.VideoSHA256, 1)
+
+	// Undoing publishes the unedited file, which then has one reference.
+	uneditedPath, cleanup, err := cfg.fetchVideoBlob(context.Background(), uploaded)
+	if err != nil {
+		t.Fatalf("fetchVideoBlob: %v", err)
+	}
+	defer cleanup()
+	undone, err := cfg.publishRendition(context.Background(), second, uneditedPath, nil, nil)
+	if err != nil {
+		t.Fatalf("publishRendition: %v", err)
+	}
+	if undone.Edited || undone.UneditedSHA256 != nil || *undone.VideoSHA256 != uploaded {
+		t.Errorf("video = %+v, want the upload published unedited", undone)
+	}
+	assertBlobRefs(t, cfg, &uploaded, 1)
+	assertBlobRefs(t, cfg, second.VideoSHA256, 0)
+}
+
+func TestPublishRenditionRenormalizesLoudness(t *testing.T) {
+	for _, normalized := range []bool{true, false} {
+		cfg := newTestConfig(t)
+		video := newPublishedTestVideo(t, cfg, "upload")
+		media := &ffmpeg.Fake{
+			ProbeResult: ffmpeg.ProbeResult{
+				Streams: []ffmpeg.Stream{testH264Stream, testAACStream},
+				Format:  ffmpeg.Format{FormatName: "mov,mp4", Duration: "20.000"},
+			},
+			// The cut came out well below the target.
+			Log: `{"input_i": "-22.0", "input_tp": "-6.0", "input_lra": "4.0", "input_thresh": "-32.0", "target_offset": "0.0"}`,
+		}
+		cfg.media = media
+		video.LoudnessNormalized = normalized
+
+		edited, err := cfg.publishRendition(context.Background(), video, writeTestFile(t, "edited.mp4", "edited"), video.VideoSHA256, nil)
+		if err != nil {
+			t.Fatalf("publishRendition: %v", err)
+		}
+		if edited.LoudnessNormalized != normalized {
+			t.Errorf("normalized %v: loudness_normalized = %v", normalized, edited.LoudnessNormalized)
+		}
+		secondPass := false
+		for _, call := range media.Calls() {
+			secondPass = secondPass || slices.ContainsFunc(call.Args, func(arg string) bool {
+				return strings.Contains(arg, "measured_I=")
+			})
+		}
+		if secondPass != normalized {
+			t.Errorf("normalized %v: normalized again = %v", normalized, secondPass)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+)
+
+// issueAccountToken records a single-use token for user and signs it.
+func (cfg *apiConfig) issueAccountToken(userID uuid.UUID, purpose database.AccountTokenPurpose, tokenType auth.TokenType, ttl time.Duration) (string, error) {
+	expiresAt := time.Now().UTC().Add(ttl)
+	record, err := cfg.db.CreateAccountToken(userID, purpose, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return auth.MakeAccountToken(userID, tokenType, record.ID, cfg.jwtKeys, ttl)
+}
+
+// consumeAccountToken validates a signed account token and marks it used.
+// It returns uuid.Nil if the token is invalid, expired or was already used.
+func (cfg *apiConfig) consumeAccountToken(token string, purpose database.AccountTokenPurpose, tokenType auth.TokenType) (uuid.UUID, error) {
+	userID, tokenID, err := auth.ValidateAccountToken(token, tokenType, cfg.jwtKeys)
+	if err != nil {
+		return uuid.Nil, nil
+	}
+	ok, err := cfg.db.UseAccountToken(tokenID, userID, purpose)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !ok {
+		return uuid.Nil, nil
+	}
+	return userID, nil
+}
+
+func (cfg *apiConfig) appURL(page, token string) string {
+	return fmt.Sprintf("%s/app/%s?token=%s", cfg.publicURL, page, url.QueryEscape(token))
+}
+
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.issueAccountToken(user.ID, database.AccountTokenEmailVerification, auth.TokenTypeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Tubely email address",
+		Body: fmt.Sprintf(
+			"Welcome to Tubely!\n\nConfirm your email address by opening this link within 24 hours:\n\n%s\n",
+			cfg.appURL("verify-email.html", token),
+		),
+	})
+}
+
+func (cfg *apiConfig) handlerEmailVerificationRequest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	err = cfg.sendVerificationEmail(r.Context(), *user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerEmailVerificationConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := cfg.consumeAccountToken(params.Token, database.AccountTokenEmailVerification, auth.TokenTypeEmailVerification)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify token", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Verification link is invalid or has expired", nil)
+		return
+	}
+
+	err = cfg.db.MarkUserEmailVerified(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Respond before looking the account up so neither the status nor the
+	// timing reveals whether the email is registered.
+	w.WriteHeader(http.StatusAccepted)
+	go cfg.sendPasswordResetEmail(normalizeEmail(params.Email))
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(email string) {
+	user, err := cfg.db.GetUserByEmail(email)
+	if err != nil {
+		log.Printf("Couldn't look up user for password reset: %v", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		return
+	}
+
+	token, err := cfg.issueAccountToken(user.ID, database.AccountTokenPasswordReset, auth.TokenTypePasswordReset, passwordResetTTL)
+	if err != nil {
+		log.Printf("Couldn't create password reset token: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Tubely account.\n\nOpen this link within an hour to choose a new one:\n\n%s\n\nIf this wasn't you, you can ignore this email.\n",
+			cfg.appURL("reset-password.html", token),
+		),
+	})
+	if err != nil {
+		log.Printf("Couldn't send password reset email: %v", err)
+	}
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
+		return
+	}
+
+	userID, err := cfg.consumeAccountToken(params.Token, database.AccountTokenPasswordReset, auth.TokenTypePasswordReset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify token", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Reset link is invalid or has expired", nil)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	err = cfg.db.UpdateUserPassword(userID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	// Whoever had the old password may still hold a session.
+	err = cfg.db.RevokeUserRefreshTokens(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	// Receiving the link proves ownership of the address.
+	err = cfg.db.MarkUserEmailVerified(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
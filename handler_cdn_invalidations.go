@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerCDNInvalidationsGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	limit := 100
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		parsed, err := strconv.Atoi(limitString)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000", err)
+			return
+		}
+		limit = parsed
+	}
+
+	invalidations, err := cfg.db.GetCDNInvalidations(limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve CDN invalidations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, invalidations)
+}
+
+func (cfg *apiConfig) handlerCDNInvalidationRetry(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	invalidationID, err := uuid.Parse(r.PathValue("invalidationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invalidation ID", err)
+		return
+	}
+
+	invalidation, err := cfg.db.GetCDNInvalidation(invalidationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve CDN invalidation", err)
+		return
+	}
+	if invalidation.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get CDN invalidation", nil)
+		return
+	}
+
+	retried, err := cfg.db.RetryCDNInvalidation(invalidationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retry CDN invalidation", err)
+		return
+	}
+	if !retried {
+		respondWithError(w, http.StatusConflict, "Only failed invalidations can be retried", nil)
+		return
+	}
+	cfg.wakeCDNInvalidations()
+
+	invalidation, err = cfg.db.GetCDNInvalidation(invalidationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve CDN invalidation", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, invalidation)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/edge"
+)
+
+// newEdgeCache sets up the built-in stand-in for CloudFront. The origin
+// defaults to the S3 bucket's public endpoint, or to this server when
+// videos are stored locally.
+func (cfg *apiConfig) newEdgeCache(dir, originURL string, maxBytes int64) (*edge.Cache, error) {
+	if originURL == "" {
+		if cfg.usesLocalVideoStorage() {
+			originURL = cfg.publicURL
+		} else {
+			originURL = "https://" + cfg.s3Bucket + ".s3." + cfg.s3Region + ".amazonaws.com"
+		}
+	}
+	origin, err := url.Parse(originURL)
+	if err != nil {
+		return nil, err
+	}
+	return edge.New(edge.Config{
+		Origin:   origin,
+		Dir:      dir,
+		MaxBytes: maxBytes,
+	})
+}
+
+func (cfg *apiConfig) handlerEdgePurge(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Paths []string `json:"paths"`
+	}
+	type response struct {
+		Purged int `json:"purged"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	if cfg.edge == nil {
+		respondWithError(w, http.StatusNotFound, "Edge cache is not enabled", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Paths) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one path is required", nil)
+		return
+	}
+	for _, path := range params.Paths {
+		if !strings.HasPrefix(path, "/") {
+			respondWithError(w, http.StatusBadRequest, "Paths must start with /", nil)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Purged: cfg.edge.Purge(params.Paths),
+	})
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -14,11 +16,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -28,41 +25,91 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(params.Email)
+	email := normalizeEmail(params.Email)
+	ip := clientIP(r)
+
+	if cfg.rejectLockedLogin(w, email, ip) {
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(email)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	if user.ID == uuid.Nil {
+		checkPasswordForUnknownUser(params.Password)
+		cfg.recordLoginAttempt(email, ip, nil, database.LoginUnknownEmail)
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+		return
+	}
 
 	err = auth.CheckPasswordHash(params.Password, user.Password)
 	if err != nil {
+		cfg.recordLoginAttempt(email, ip, &user.ID, database.LoginBadPassword)
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
-
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
-	)
+	challengeToken, err := cfg.secondFactorChallenge(user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if challengeToken != "" {
+		cfg.recordLoginAttempt(email, ip, &user.ID, database.LoginTOTPRequired)
+		respondWithJSON(w, http.StatusOK, totpChallengeResponse{
+			TOTPRequired:   true,
+			ChallengeToken: challengeToken,
+		})
 		return
 	}
 
+	cfg.recordLoginAttempt(email, ip, &user.ID, database.LoginSucceeded)
+	cfg.respondWithNewSession(w, r, user)
+}
+
+// createSession issues an access/refresh token pair for a user who has
+// completed every login step, recording the client it was issued to.
+func (cfg *apiConfig) createSession(r *http.Request, user database.User) (string, string, error) {
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't create refresh token: %w", err)
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+	session, err := cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
 		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		return "", "", fmt.Errorf("couldn't save refresh token: %w", err)
+	}
+
+	accessToken, err := auth.MakeJWT(
+		user.ID,
+		session.ID,
+		cfg.jwtKeys,
+		time.Hour*24*30,
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func (cfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	accessToken, refreshToken, err := cfg.createSession(r, user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
 		return
 	}
 
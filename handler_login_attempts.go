@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+func (cfg *apiConfig) handlerLoginAttemptsGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	email := normalizeEmail(r.URL.Query().Get("email"))
+	if email == "" {
+		respondWithError(w, http.StatusBadRequest, "email query parameter is required", nil)
+		return
+	}
+
+	limit := 100
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		parsed, err := strconv.Atoi(limitString)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000", err)
+			return
+		}
+		limit = parsed
+	}
+
+	attempts, err := cfg.db.GetLoginAttempts(email, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve login attempts", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, attempts)
+}
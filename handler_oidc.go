@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcAuthRequestTTL = 10 * time.Minute
+	// oidcStateCookie ties a login to the browser that started it, so an
+	// attacker can't have a victim's browser finish the attacker's login.
+	oidcStateCookie = "tubely_oidc_state"
+	oidcCookiePath  = "/api/oidc/"
+)
+
+// handlerOIDCLogin sends the browser to the identity provider, remembering
+// the login's state both server-side and in a cookie the callback checks.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", nil)
+		return
+	}
+
+	authReq, err := oidc.NewAuthRequest()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+
+	authURL, err := cfg.oidc.AuthCodeURL(r.Context(), authReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach identity provider", err)
+		return
+	}
+
+	err = cfg.db.CreateOIDCAuthRequest(database.OIDCAuthRequest{
+		State:        authReq.State,
+		Nonce:        authReq.Nonce,
+		CodeVerifier: authReq.CodeVerifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcAuthRequestTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+
+	cfg.setOIDCStateCookie(w, authReq.State, int(oidcAuthRequestTTL/time.Second))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCCallback finishes the browser flow. The state must match the
+// cookie set when this browser started the login. Tokens are handed to the
+// app in the URL fragment, which browsers never send to servers or log.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", nil)
+		return
+	}
+
+	cookieState := ""
+	if cookie, err := r.Cookie(oidcStateCookie); err == nil {
+		cookieState = cookie.Value
+	}
+	cfg.setOIDCStateCookie(w, "", -1)
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		log.Printf("Identity provider returned %s: %s", errCode, query.Get("error_description"))
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Sign-in was cancelled or denied"}})
+		return
+	}
+
+	// Checked before the auth request is consumed, so a forged callback
+	// can't use up a login the victim started.
+	state := query.Get("state")
+	if cookieState == "" || subtle.ConstantTimeCompare([]byte(cookieState), []byte(state)) != 1 {
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Sign-in wasn't started in this browser, please try again"}})
+		return
+	}
+
+	authReq, err := cfg.db.ConsumeOIDCAuthRequest(state)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't complete login", err)
+		return
+	}
+	if authReq == nil {
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Sign-in expired, please try again"}})
+		return
+	}
+
+	claims, err := cfg.oidc.Exchange(r.Context(), query.Get("code"), oidc.AuthRequest{
+		State:        authReq.State,
+		Nonce:        authReq.Nonce,
+		CodeVerifier: authReq.CodeVerifier,
+	})
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Couldn't verify sign-in with the identity provider"}})
+		return
+	}
+
+	user, err := cfg.userForOIDCClaims(claims)
+	if errors.Is(err, errOIDCUnverifiedEmail) {
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Your identity provider account has no verified email"}})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't provision user", err)
+		return
+	}
+
+	challengeToken, err := cfg.secondFactorChallenge(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if challengeToken != "" {
+		cfg.recordLoginAttempt(normalizeEmail(user.Email), clientIP(r), &user.ID, database.LoginTOTPRequired)
+		cfg.redirectToApp(w, r, url.Values{"challenge_token": {challengeToken}})
+		return
+	}
+
+	accessToken, refreshToken, err := cfg.createSession(r, *user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+	cfg.recordLoginAttempt(normalizeEmail(user.Email), clientIP(r), &user.ID, database.LoginSucceeded)
+	cfg.redirectToApp(w, r, url.Values{
+		"token":         {accessToken},
+		"refresh_token": {refreshToken},
+	})
+}
+
+var errOIDCUnverifiedEmail = errors.New("identity provider didn't return a verified email")
+
+// userForOIDCClaims finds the user linked to the external identity. New
+// identities are linked to an existing account with the same email, or a
+// new account is created, but only when the provider vouches for the email.
+func (cfg *apiConfig) userForOIDCClaims(claims oidc.Claims) (*database.User, error) {
+	issuer, err := claims.GetIssuer()
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := cfg.db.GetUserIDByIdentity(issuer, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if userID != uuid.Nil {
+		return cfg.db.GetUser(userID)
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errOIDCUnverifiedEmail
+	}
+
+	existing, err := cfg.db.GetUserByEmail(claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	user := &existing
+	if existing.ID == uuid.Nil {
+		// SSO users have no local password; a random one keeps the column
+		// valid and they can still set one through password reset.
+		randomPassword := make([]byte, 32)
+		_, err = rand.Read(randomPassword)
+		if err != nil {
+			return nil, err
+		}
+		hashedPassword, err := auth.HashPassword(hex.EncodeToString(randomPassword))
+		if err != nil {
+			return nil, err
+		}
+		user, err = cfg.db.CreateUser(database.CreateUserParams{
+			Email:    claims.Email,
+			Password: hashedPassword,
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Provisioned user %s from identity provider %s", user.Email, issuer)
+	}
+
+	err = cfg.db.LinkUserIdentity(issuer, claims.Subject, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.db.MarkUserEmailVerified(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.db.GetUser(user.ID)
+}
+
+// setOIDCStateCookie sets the login state cookie, or clears it when maxAge
+// is negative. Lax lets it ride along on the provider's top-level redirect
+// back.
+func (cfg *apiConfig) setOIDCStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (cfg *apiConfig) redirectToApp(w http.ResponseWriter, r *http.Request, fragment url.Values) {
+	http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testOIDCClientID = "tubely"
+
+// stubIdP is an identity provider that signs in whoever asks as email,
+// serving discovery, token and JWKS endpoints.
+type stubIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	email  string
+	// nonce goes in the ID token; tests copy it from the login redirect.
+	nonce string
+}
+
+func newStubIdP(t *testing.T, email string) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	idp := &stubIdP{key: key, email: email}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, oidc.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    idp.server.URL,
+				Subject:   "subject-1",
+				Audience:  jwt.ClaimStrings{testOIDCClientID},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+			Nonce:         idp.nonce,
+			Email:         idp.email,
+			EmailVerified: true,
+		})
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign ID token", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// startOIDCLogin runs the login handler, returning the state the provider
+// was sent and the state cookie the browser got.
+func startOIDCLogin(t *testing.T, cfg *apiConfig, idp *stubIdP) (string, *http.Cookie) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	cfg.handlerOIDCLogin(rr, httptest.NewRequest("GET", "/api/oidc/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d: %s", rr.Code, http.StatusFound, rr.Body)
+	}
+	authURL, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	idp.nonce = authURL.Query().Get("nonce")
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return authURL.Query().Get("state"), cookie
+		}
+	}
+	t.Fatal("login didn't set the state cookie")
+	return "", nil
+}
+
+// finishOIDCLogin runs the callback handler and returns the values handed
+// to the app in the redirect's fragment.
+func finishOIDCLogin(t *testing.T, cfg *apiConfig, state string, cookie *http.Cookie) (url.Values, *httptest.ResponseRecorder) {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/api/oidc/callback?"+url.Values{"code": {"good-code"}, "state": {state}}.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	cfg.handlerOIDCCallback(rr, r)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("callback status = %d, want %d: %s", rr.Code, http.StatusFound, rr.Body)
+	}
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	return fragment, rr
+}
+
+func newOIDCTestConfig(t *testing.T, idp *stubIdP) *apiConfig {
+	t.Helper()
+	cfg := newTestConfig(t)
+	cfg.oidc = oidc.NewClient(oidc.Config{
+		Issuer:      idp.server.URL,
+		ClientID:    testOIDCClientID,
+		RedirectURL: cfg.publicURL + "/api/oidc/callback",
+	})
+	return cfg
+}
+
+func TestOIDCLoginSetsStateCookie(t *testing.T) {
+	idp := newStubIdP(t, "sso@example.com")
+	cfg := newOIDCTestConfig(t, idp)
+
+	state, cookie := startOIDCLogin(t, cfg, idp)
+
+	if cookie.Value != state {
+		t.Errorf("cookie holds %q, want the state %q", cookie.Value, state)
+	}
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie %+v must be HttpOnly and SameSite=Lax", cookie)
+	}
+	if cookie.MaxAge <= 0 {
+		t.Errorf("cookie MaxAge = %d, want it to expire with the login", cookie.MaxAge)
+	}
+}
+
+func TestOIDCCallback(t *testing.T) {
+	idp := newStubIdP(t, "sso@example.com")
+	cfg := newOIDCTestConfig(t, idp)
+
+	state, cookie := startOIDCLogin(t, cfg, idp)
+	fragment, rr := finishOIDCLogin(t, cfg, state, cookie)
+
+	if fragment.Get("sso_error") != "" {
+		t.Fatalf("callback failed: %s", fragment.Get("sso_error"))
+	}
+	userID, _, err := cfg.validateAccessToken(fragment.Get("token"))
+	if err != nil {
+		t.Fatalf("callback returned an invalid access token: %v", err)
+	}
+	user, err := cfg.db.GetUserByEmail("sso@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if user.ID != userID || user.EmailVerifiedAt == nil {
+		t.Errorf("signed in as %s, want verified user %+v", userID, user)
+	}
+
+	cleared := false
+	for _, c := range rr.Result().Cookies() {
+		cleared = cleared || (c.Name == oidcStateCookie && c.MaxAge < 0)
+	}
+	if !cleared {
+		t.Error("callback didn't clear the state cookie")
+	}
+}
+
+func TestOIDCCallbackRejectsForeignState(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie func(victimCookie *http.Cookie) *http.Cookie
+	}{
+		{
+			name:   "no cookie",
+			cookie: func(*http.Cookie) *http.Cookie { return nil },
+		},
+		{
+			name:   "another login's cookie",
+			cookie: func(victimCookie *http.Cookie) *http.Cookie { return victimCookie },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newStubIdP(t, "attacker@example.com")
+			cfg := newOIDCTestConfig(t, idp)
+
+			// The attacker starts a login and has the victim's browser,
+			// which started its own, finish it.
+			_, victimCookie := startOIDCLogin(t, cfg, idp)
+			attackerState, _ := startOIDCLogin(t, cfg, idp)
+			fragment, _ := finishOIDCLogin(t, cfg, attackerState, tt.cookie(victimCookie))
+
+			if fragment.Get("sso_error") == "" || fragment.Get("token") != "" {
+				t.Fatalf("forged callback signed in: %v", fragment)
+			}
+			user, err := cfg.db.GetUserByEmail("attacker@example.com")
+			if err != nil {
+				t.Fatalf("GetUserByEmail: %v", err)
+			}
+			if user.Email != "" {
+				t.Errorf("forged callback provisioned %+v", user)
+			}
+
+			// The attacker's login wasn't used up, so the browser that
+			// started it can still finish it.
+			fragment, _ = finishOIDCLogin(t, cfg, attackerState, &http.Cookie{Name: oidcStateCookie, Value: attackerState})
+			if fragment.Get("token") == "" {
+				t.Errorf("login was consumed by the forged callback: %v", fragment)
+			}
+		})
+	}
+}
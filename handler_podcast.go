@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const itunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+// RSS 2.0 with the iTunes tags podcast apps look for.
+type podcastFeed struct {
+	XMLName xml.Name       `xml:"rss"`
+	Version string         `xml:"version,attr"`
+	Itunes  string         `xml:"xmlns:itunes,attr"`
+	Channel podcastChannel `xml:"channel"`
+}
+
+type podcastChannel struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description"`
+	Items       []podcastItem `xml:"item"`
+}
+
+type podcastItem struct {
+	Title       string           `xml:"title"`
+	Description string           `xml:"description"`
+	GUID        podcastGUID      `xml:"guid"`
+	PubDate     string           `xml:"pubDate"`
+	Enclosure   podcastEnclosure `xml:"enclosure"`
+	Duration    string           `xml:"itunes:duration,omitempty"`
+	Image       *podcastImage    `xml:"itunes:image,omitempty"`
+}
+
+type podcastGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type podcastEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type podcastImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// handlerPodcastFeed serves a user's public videos that have an audio-only
+// rendition as an RSS podcast feed. Podcast apps can't sign in, so private
+// videos are always left out.
+func (cfg *apiConfig) handlerPodcastFeed(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", nil)
+		return
+	}
+
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	feed := podcastFeed{
+		Version: "2.0",
+		Itunes:  itunesNamespace,
+		Channel: podcastChannel{
+			Title:       "Tubely videos",
+			Link:        cfg.publicURL + "/app/",
+			Description: "Audio from public videos on Tubely",
+			Items:       []podcastItem{},
+		},
+	}
+	for _, video := range videos {
+		if video.IsPrivate || video.AudioURL == nil || video.AudioSHA256 == nil {
+			continue
+		}
+		blob, err := cfg.db.GetBlob(cfg.videoBlobStorage(), *video.AudioSHA256)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audio", err)
+			return
+		}
+		if blob.SHA256 == "" {
+			continue
+		}
+
+		item := podcastItem{
+			Title:       video.Title,
+			Description: video.Description,
+			GUID:        podcastGUID{Value: video.ID.String()},
+			PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+			Enclosure:   podcastEnclosure{URL: *video.AudioURL, Length: blob.Size, Type: blob.ContentType},
+		}
+		if video.DurationMS != nil {
+			item.Duration = strconv.FormatInt(*video.DurationMS/1000, 10)
+		}
+		if video.ThumbnailURL != nil {
+			item.Image = &podcastImage{Href: *video.ThumbnailURL}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't render feed", err)
+		return
+	}
+	body = append([]byte(xml.Header), body...)
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "podcast.xml", time.Time{}, bytes.NewReader(body))
+}
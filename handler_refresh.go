@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"time"
 
@@ -23,10 +24,21 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token is invalid, expired or revoked", nil)
+		return
+	}
+
+	session, err := cfg.db.GetRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get session", err)
+		return
+	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
+		session.ID,
+		cfg.jwtKeys,
 		time.Hour,
 	)
 	if err != nil {
@@ -34,6 +46,11 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = cfg.db.TouchRefreshToken(refreshToken, clientIP(r))
+	if err != nil {
+		log.Printf("Couldn't record session use: %v", err)
+	}
+
 	respondWithJSON(w, http.StatusOK, response{
 		Token: accessToken,
 	})
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "Tubely"
+	recoveryCodeCount = 10
+	loginChallengeTTL = 5 * time.Minute
+)
+
+type totpChallengeResponse struct {
+	TOTPRequired   bool   `json:"totp_required"`
+	ChallengeToken string `json:"challenge_token"`
+}
+
+// secondFactorChallenge returns a login challenge token if the user has
+// two-factor auth enabled, or "" if their first factor is enough.
+func (cfg *apiConfig) secondFactorChallenge(userID uuid.UUID) (string, error) {
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		return "", err
+	}
+	if totp == nil || totp.EnabledAt == nil {
+		return "", nil
+	}
+	return cfg.issueAccountToken(userID, database.AccountTokenLoginChallenge, auth.TokenTypeLoginChallenge, loginChallengeTTL)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused
+// recovery code, consuming whichever was used.
+func (cfg *apiConfig) checkSecondFactor(totp database.UserTOTP, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := auth.ValidateTOTP(totp.Secret, code, totp.LastStep, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return cfg.db.AdvanceTOTPStep(totp.UserID, step)
+	}
+	if recoveryCode != "" {
+		return cfg.db.UseRecoveryCode(totp.UserID, auth.HashRecoveryCode(recoveryCode))
+	}
+	return false, nil
+}
+
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp != nil && totp.EnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create secret", err)
+		return
+	}
+	err = cfg.db.SetPendingTOTP(userID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Secret: secret,
+		URI:    auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp == nil {
+		respondWithError(w, http.StatusBadRequest, "Start enrollment first", nil)
+		return
+	}
+	if totp.EnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, totp.LastStep, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+
+	err = cfg.db.EnableTOTP(userID, step, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	// This is the only time the plaintext codes are available.
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if totp.EnabledAt != nil {
+		user, err := cfg.db.GetUser(userID)
+		if err != nil || user == nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		// A stolen access token mustn't get more guesses at the code than
+		// logging in would.
+		email := normalizeEmail(user.Email)
+		ip := clientIP(r)
+		if cfg.rejectLockedLogin(w, email, ip) {
+			return
+		}
+
+		ok, err = cfg.checkSecondFactor(*totp, params.Code, params.RecoveryCode)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+			return
+		}
+		if !ok {
+			cfg.recordLoginAttempt(email, ip, &user.ID, database.LoginBadTOTP)
+			respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+			return
+		}
+	}
+
+	err = cfg.db.DeleteUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, challengeID, err := auth.ValidateAccountToken(params.ChallengeToken, auth.TokenTypeLoginChallenge, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge is invalid or has expired", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge is invalid or has expired", err)
+		return
+	}
+
+	// Codes are only a million possibilities, so they share the password
+	// lockout budget.
+	email := normalizeEmail(user.Email)
+	ip := clientIP(r)
+	if cfg.rejectLockedLogin(w, email, ip) {
+		return
+	}
+
+	// Check the challenge before the code so a recovery code isn't burned
+	// on a challenge that has already been used.
+	usable, err := cfg.db.AccountTokenUsable(challengeID, userID, database.AccountTokenLoginChallenge)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login challenge", err)
+		return
+	}
+	if !usable {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge is invalid or has expired", nil)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp == nil || totp.EnabledAt == nil {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge is invalid or has expired", nil)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(*totp, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		cfg.recordLoginAttempt(email, ip, &user.ID, database.LoginBadTOTP)
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	ok, err = cfg.db.UseAccountToken(challengeID, userID, database.AccountTokenLoginChallenge)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't complete login", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Login challenge is invalid or has expired", nil)
+		return
+	}
+
+	cfg.recordLoginAttempt(email, ip, &user.ID, database.LoginSucceeded)
+	cfg.respondWithNewSession(w, r, *user)
+}
@@ -1,99 +1,78 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
-	"io"
-	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
-
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	dbVideo, userID, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
-
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	fmt.Println("uploading thumbnail for video", dbVideo.ID, "by user", userID)
 
 	// TODO: implement the upload here
 	const maxMemory = 10 << 20
 
-	err = r.ParseMultipartForm(maxMemory)
+	err := r.ParseMultipartForm(maxMemory)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Multipart parsing error", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse multipart form", err)
 		return
 	}
 
 	file, fileHeader, err := r.FormFile("thumbnail")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error while geting image data from form", err)
+		respondWithError(w, http.StatusBadRequest, "Missing thumbnail file", err)
 		return
 	}
-	mediaType, _, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error while parsing mediatype", err)
+	defer file.Close()
+
+	upload, ok := receiveUpload(w, r, fileHeader.Header, file, "tubely-thumbnail")
+	if !ok {
 		return
 	}
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusInternalServerError, "Error while parsing mediatype", err)
+	defer os.Remove(upload.Path)
+
+	mediaType, err := checkMediaType(fileHeader.Header.Get("Content-Type"), upload.Path, thumbnailMediaTypes)
+	if err != nil {
+		respondWithMediaError(w, err)
 		return
 	}
-
-	extension := strings.Split(mediaType, "/")[1]
-
-	b := make([]byte, 32)
-	rand.Read(b)
-	filenameBytes := make([]byte, base64.RawURLEncoding.EncodedLen(len(b)))
-	base64.RawURLEncoding.Encode(filenameBytes, b)
-	filenameOS := string(filenameBytes) + "." + extension
-	filePath := filepath.Join(cfg.assetsRoot, filenameOS)
-
-	fileOnFS, err := os.Create(filePath)
-
+	err = validateImage(upload.Path)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Filesystem error ", err)
+		respondWithMediaError(w, err)
 		return
 	}
 
-	io.Copy(fileOnFS, file)
+	extension := strings.Split(mediaType, "/")[1]
 
-	dbVideo, err := cfg.db.GetVideo(videoID)
+	blob, err := cfg.storeBlob(r.Context(), blobStorageAssets, upload, "", "."+extension, mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized access to th e video", err)
+		respondWithStoreError(w, "Filesystem error ", err)
 		return
 	}
 
-	thumbnail_dataurl := fmt.Sprintf("http://localhost:%v/assets/%v", 8091, filenameOS)
+	thumbnail_dataurl := cfg.publicURL + "/assets/" + blob.Key
 	//fmt.Printf("thumbnail_url: %v", thumbnail_dataurl)
 
+	oldThumbnailURL := dbVideo.ThumbnailURL
+	oldThumbnailSHA256 := dbVideo.ThumbnailSHA256
 	dbVideo.ThumbnailURL = &thumbnail_dataurl
-	err = cfg.db.UpdateVideo(dbVideo)
+	dbVideo.ThumbnailSHA256 = &blob.SHA256
+	err = cfg.db.UpdateVideo(dbVideo, dbVideo.VideoSHA256)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error updating video metadata", err)
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		respondWithUpdateError(w, "Error updating video metadata", err)
 		return
 	}
+	cfg.releaseBlob(blobStorageAssets, oldThumbnailSHA256)
+	if oldThumbnailURL == nil || *oldThumbnailURL != thumbnail_dataurl {
+		cfg.invalidateCDN("thumbnail replaced for video "+dbVideo.ID.String(), oldThumbnailURL)
+	}
 
 	respondWithJSON(w, http.StatusOK, dbVideo)
 }
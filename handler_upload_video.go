@@ -1,92 +1,127 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"mime"
+	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"strings"
+	"strconv"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
 )
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
 
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	dbVideo, userID, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	file, fileHeader, err := r.FormFile("video")
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusBadRequest, "Missing video file", err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
-	dbVideo, err := cfg.db.GetVideo(videoID)
+	defer file.Close()
 
-	if err != nil || dbVideo.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized access to the video", err)
+	upload, ok := receiveUpload(w, r, fileHeader.Header, file, "tubely-upload.mp4")
+	if !ok {
 		return
 	}
+	defer os.Remove(upload.Path)
 
-	file, fileHeader, err := r.FormFile("video")
+	mediaType, err := checkMediaType(fileHeader.Header.Get("Content-Type"), upload.Path, cfg.videoInputTypes)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error while geting video data from form", err)
+		respondWithMediaError(w, err)
 		return
 	}
 
-	defer file.Close()
+	keepSource := false
+	if keepSourceString := r.FormValue("keep_source"); keepSourceString != "" {
+		keepSource, err = strconv.ParseBool(keepSourceString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "keep_source must be true or false", err)
+			return
+		}
+	}
 
-	mediaType, _, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Type"))
+	wm, watermarked, err := cfg.watermarkFor(dbVideo.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error while parsing mediatype", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve watermark", err)
 		return
 	}
-
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusInternalServerError, "Error while parsing mediatype", err)
+	// A watermarked video's source would leak the unmarked upload; editors
+	// get it as the original instead.
+	if keepSource && watermarked {
+		respondWithError(w, http.StatusBadRequest, "keep_source isn't available for watermarked videos", nil)
 		return
 	}
-	extension := strings.Split(mediaType, "/")[1]
 
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	normalize := cfg.loudnessNormalize
+	if normalizeString := r.FormValue("normalize_loudness"); normalizeString != "" {
+		normalize, err = strconv.ParseBool(normalizeString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "normalize_loudness must be true or false", err)
+			return
+		}
+	}
 
+	probe, err := validateVideo(ctx, cfg.media, upload.Path)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Filesystem error ", err)
+		respondWithMediaError(w, err)
 		return
 	}
 
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
-
-	_, err = io.Copy(tempFile, file)
+	mp4FilePath, err := convertToMP4(ctx, cfg.media, upload.Path, mediaType, probe)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to copy file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't convert video to MP4", err)
 		return
 	}
+	if mp4FilePath != upload.Path {
+		defer os.Remove(mp4FilePath)
+	}
+
+	if watermarked {
+		mp4FilePath, err = applyWatermark(ctx, cfg.media, mp4FilePath, wm)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't watermark video", err)
+			return
+		}
+		defer os.Remove(mp4FilePath)
+	}
+
+	// Loudness is measured on every upload with sound, so it can be shown
+	// even where normalizing is off. Measuring is best effort.
+	var loudness *float64
+	loudnessNormalized := false
+	if probe.Audio != nil {
+		measured, err := measureLoudness(ctx, cfg.media, mp4FilePath, cfg.loudnessTarget)
+		switch {
+		case errors.Is(err, errSilentAudio):
+		case err != nil:
+			log.Printf("Couldn't measure loudness for video %s: %v", dbVideo.ID, err)
+		default:
+			loudness = &measured.IntegratedLUFS
+			if normalize && needsNormalizing(measured, cfg.loudnessTarget) {
+				mp4FilePath, err = normalizeLoudness(ctx, cfg.media, mp4FilePath, measured, cfg.loudnessTarget)
+				if err != nil {
+					respondWithError(w, http.StatusInternalServerError, "Couldn't normalize loudness", err)
+					return
+				}
+				defer os.Remove(mp4FilePath)
+			}
+			// Audio already within tolerance of the target counts too.
+			loudnessNormalized = normalize
+		}
+	}
 
-	faststartFilePath, err := processVideoForFastStart(tempFile.Name())
+	faststartFilePath, err := processVideoForFastStart(ctx, cfg.media, mp4FilePath)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed to process the file", err)
 		return
@@ -94,48 +129,106 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 
 	defer os.Remove(faststartFilePath)
 
-	faststartFile, err := os.Open(faststartFilePath)
+	prefix := aspectRatioToPrefix[getVideoAspectRatio(probe.Video.Width, probe.Video.Height)]
+
+	processed, err := hashFile(faststartFilePath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to open processed file", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to hash processed file", err)
 		return
 	}
-	defer faststartFile.Close()
-
-	tempFile.Seek(0, io.SeekStart)
 
-	ratio, err := getVideoAspectRatio(tempFile.Name())
+	blob, videoURL, err := cfg.storeVideoBlob(ctx, dbVideo.ID, processed, prefix, ".mp4", "video/mp4")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error getVideoAspectRatio", err)
+		respondWithStoreError(w, "Error storing video", err)
 		return
 	}
-	prefix := aspectRatioToPrefix[ratio]
+	fmt.Println(videoURL)
 
-	b := make([]byte, 32)
-	rand.Read(b)
-	filenameBytes := make([]byte, base64.RawURLEncoding.EncodedLen(len(b)))
-	base64.RawURLEncoding.Encode(filenameBytes, b)
-	fileName := prefix + string(filenameBytes) + "." + extension
+	var sourceURL, sourceSHA256 *string
+	if keepSource {
+		sourceBlob, sourceBlobURL, err := cfg.storeVideoBlob(ctx, dbVideo.ID, upload, "source/", videoContainerExtensions[mediaType], mediaType)
+		if err != nil {
+			cfg.releaseBlob(blob.Storage, &blob.SHA256)
+			respondWithStoreError(w, "Error storing source video", err)
+			return
+		}
+		sourceURL, sourceSHA256 = &sourceBlobURL, &sourceBlob.SHA256
+	}
 
-	putObjectInput := &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(fileName),
-		Body:        faststartFile,
-		ContentType: aws.String("video/mp4"),
+	// The untouched upload of a watermarked video is kept for editors.
+	var originalSHA256 *string
+	if watermarked {
+		originalBlob, _, err := cfg.storeVideoBlob(ctx, dbVideo.ID, upload, "original/", videoContainerExtensions[mediaType], mediaType)
+		if err != nil {
+			cfg.releaseBlob(blob.Storage, &blob.SHA256)
+			cfg.releaseBlob(cfg.videoBlobStorage(), sourceSHA256)
+			respondWithStoreError(w, "Error storing original video", err)
+			return
+		}
+		originalSHA256 = &originalBlob.SHA256
 	}
 
-	_, err = cfg.s3Client.PutObject(ctx, putObjectInput)
+	assets := cfg.makeVideoAssets(ctx, dbVideo.ID, faststartFilePath, probe)
 
+	oldSheets, err := cfg.db.GetVideoStoryboardSheets(dbVideo.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error putting object on S3 bucket", err)
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		cfg.releaseBlob(cfg.videoBlobStorage(), sourceSHA256)
+		cfg.releaseBlob(cfg.videoBlobStorage(), originalSHA256)
+		cfg.releaseVideoAssets(assets)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storyboard", err)
 		return
 	}
-	videoURL := "https://" + cfg.s3CfDistribution + "/" + fileName
-	fmt.Println(videoURL)
+
 	// videoURL := cfg.s3Bucket + "," + fileName
+	oldVideo := dbVideo
 	dbVideo.VideoURL = &videoURL
-	err = cfg.db.UpdateVideo(dbVideo)
+	dbVideo.VideoSHA256 = &blob.SHA256
+	dbVideo.SourceURL = sourceURL
+	dbVideo.SourceSHA256 = sourceSHA256
+	dbVideo.Watermarked = watermarked
+	dbVideo.OriginalSHA256 = originalSHA256
+	dbVideo.LoudnessLUFS = loudness
+	dbVideo.LoudnessNormalized = loudnessNormalized
+	// A new upload starts over from an unedited video.
+	dbVideo.Edited, dbVideo.UneditedSHA256 = false, nil
+	sheetSHA256s := cfg.setVideoAssets(&dbVideo, assets, probe)
+	// Only one upload or edit may replace the file this one started from.
+	err = cfg.db.UpdateVideo(dbVideo, oldVideo.VideoSHA256)
+	if err != nil {
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		cfg.releaseBlob(cfg.videoBlobStorage(), sourceSHA256)
+		cfg.releaseBlob(cfg.videoBlobStorage(), originalSHA256)
+		cfg.releaseVideoAssets(assets)
+		respondWithUpdateError(w, "Error updating video metadata", err)
+		return
+	}
+	cfg.finishVideoAssets(dbVideo, oldVideo, sheetSHA256s, oldSheets)
+	if oldVideo.Edited {
+		err = cfg.db.SetVideoEditSegments(dbVideo.ID, nil)
+		if err != nil {
+			log.Printf("Couldn't clear edit of video %s: %v", dbVideo.ID, err)
+		}
+	}
+	cfg.releaseBlob(cfg.videoBlobStorage(), oldVideo.VideoSHA256)
+	cfg.releaseBlob(cfg.videoBlobStorage(), oldVideo.SourceSHA256)
+	cfg.releaseBlob(cfg.videoBlobStorage(), oldVideo.OriginalSHA256)
+	cfg.releaseBlob(cfg.videoBlobStorage(), oldVideo.UneditedSHA256)
+	replaced := []*string{}
+	if oldVideo.VideoURL == nil || *oldVideo.VideoURL != videoURL {
+		replaced = append(replaced, oldVideo.VideoURL)
+	}
+	if oldVideo.SourceURL != nil && (sourceURL == nil || *oldVideo.SourceURL != *sourceURL) {
+		replaced = append(replaced, oldVideo.SourceURL)
+	}
+	cfg.invalidateCDN("video replaced for video "+dbVideo.ID.String(), replaced...)
+	cfg.dropAutoCaptions(dbVideo)
+	if probe.Audio != nil {
+		cfg.requestTranscription(dbVideo.ID)
+	}
+	dbVideo, err = cfg.videoForViewer(dbVideo, userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error updating video metadata", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
 		return
 	}
 	// dbVideo, err = cfg.dbVideoToSignedVideo(dbVideo)
@@ -147,48 +240,23 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	respondWithJSON(w, http.StatusOK, dbVideo)
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	command := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	var commandOutputBuffer bytes.Buffer
-	command.Stdout = &commandOutputBuffer
-	command.Run()
-
-	var results FFProbeResult
-	err := json.Unmarshal(commandOutputBuffer.Bytes(), &results)
-	if err != nil {
-		return "Error", err
-	}
-
-	if len(results.Streams) == 0 {
-		return "Error", fmt.Errorf("no streams found")
-	}
-
-	width := results.Streams[0].Width
-	height := results.Streams[0].Height
-
+func getVideoAspectRatio(width, height int) string {
 	if width/height == 1 {
-		return "16:9", nil
+		return "16:9"
 	}
 	if width/height == 0 {
-		return "9:16", nil
+		return "9:16"
 	}
 
-	return "other", nil
-
+	return "other"
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
+func processVideoForFastStart(ctx context.Context, media ffmpeg.MediaTool, filePath string) (string, error) {
 	outputFilePath := filePath + ".processing"
-	command := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputFilePath)
-
-	var stdout, stderr bytes.Buffer
-	command.Stdout = &stdout
-	command.Stderr = &stderr
-
-	err := command.Run()
-
+	err := media.Remux(ctx, filePath, outputFilePath, ffmpeg.RemuxOptions{Format: "mp4", FastStart: true})
 	if err != nil {
-		return "Error", err
+		os.Remove(outputFilePath)
+		return "", err
 	}
 
 	return outputFilePath, nil
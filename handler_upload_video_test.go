@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+func TestUploadRejectsKeepSourceWhenWatermarked(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.media = &ffmpeg.Fake{ProbeResult: ffmpeg.ProbeResult{
+		Streams: []ffmpeg.Stream{testH264Stream},
+		Format:  ffmpeg.Format{FormatName: "mov,mp4", Duration: "20.000"},
+	}}
+	cfg.watermark = &watermark{ImagePath: writeTestFile(t, "logo.png", "logo")}
+	owner, token := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, owner)
+
+	h := mutationHandler{
+		handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.handlerUploadVideo },
+		newRequest: func(t *testing.T, videoID string) *http.Request {
+			mp4 := []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+			return newMultipartRequest(t, "POST", "/api/video_upload/"+videoID+"?keep_source=true", "video", "video.mp4", "video/mp4", mp4)
+		},
+	}
+	rr := serveMutation(t, cfg, h, video.ID.String(), token)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusBadRequest, rr.Body)
+	}
+
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if stored.VideoURL != nil {
+		t.Errorf("video_url = %s, want the upload refused", *stored.VideoURL)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -22,6 +23,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	params.Email = normalizeEmail(params.Email)
 	if params.Password == "" || params.Email == "" {
 		respondWithError(w, http.StatusBadRequest, "Email and password are required", nil)
 		return
@@ -42,5 +44,11 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = cfg.sendVerificationEmail(r.Context(), *user)
+	if err != nil {
+		// The account is usable without it and the user can ask for another.
+		log.Printf("Couldn't send verification email to %s: %v", user.Email, err)
+	}
+
 	respondWithJSON(w, http.StatusCreated, user)
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxCaptionFileSize = 2 << 20
+	maxCaptionLabelLen = 100
+
+	errCodeInvalidCaptions = "invalid_captions"
+	errCodeInvalidLanguage = "invalid_language"
+)
+
+// captionLanguagePattern accepts BCP 47 language tags such as "en", "pt-BR"
+// or "zh-Hant".
+var captionLanguagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// canonicalLanguage validates a language tag and puts it in its usual case,
+// so "EN-us" and "en-US" name the same track.
+func canonicalLanguage(tag string) (string, bool) {
+	if !captionLanguagePattern.MatchString(tag) {
+		return "", false
+	}
+	subtags := strings.Split(tag, "-")
+	for i, subtag := range subtags {
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(subtag)
+		case len(subtag) == 2:
+			subtags[i] = strings.ToUpper(subtag)
+		case len(subtag) == 4:
+			subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		default:
+			subtags[i] = strings.ToLower(subtag)
+		}
+	}
+	return strings.Join(subtags, "-"), true
+}
+
+func (cfg *apiConfig) handlerVideoCaptionsList(w http.ResponseWriter, r *http.Request) {
+	video, viewerID, ok := cfg.videoForViewing(w, r)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.videoForViewer(video, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video.Captions)
+}
+
+// storeCaptionBlob stores a WebVTT caption track with the video files, so
+// private videos' captions need the same access as their video.
+func (cfg *apiConfig) storeCaptionBlob(ctx context.Context, videoID uuid.UUID, f hashedFile) (database.Blob, string, error) {
+	return cfg.storeVideoBlob(ctx, videoID, f, "captions/", ".vtt", "text/vtt")
+}
+
+// handlerVideoCaptionsUpload adds or replaces the caption track for a
+// language. SRT files are converted to WebVTT.
+func (cfg *apiConfig) handlerVideoCaptionsUpload(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	language, ok := canonicalLanguage(r.PathValue("language"))
+	if !ok {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidLanguage, "Language must be a BCP 47 tag such as en or pt-BR", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionFileSize+1<<20)
+	file, fileHeader, err := r.FormFile("captions")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing captions file", err)
+		return
+	}
+	defer file.Close()
+	if fileHeader.Size > maxCaptionFileSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Captions file is too large", nil)
+		return
+	}
+
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		label = language
+	}
+	if len(label) > maxCaptionLabelLen {
+		respondWithError(w, http.StatusBadRequest, "Label is too long", nil)
+		return
+	}
+
+	upload, ok := receiveUpload(w, r, fileHeader.Header, file, "tubely-captions")
+	if !ok {
+		return
+	}
+	defer os.Remove(upload.Path)
+
+	data, err := os.ReadFile(upload.Path)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read captions", err)
+		return
+	}
+	vtt, duration, err := captions.ToWebVTT(data)
+	if errors.Is(err, captions.ErrInvalid) {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidCaptions, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't convert captions", err)
+		return
+	}
+
+	converted, err := hashToTempFile(bytes.NewReader(vtt), "tubely-captions.vtt")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write captions", err)
+		return
+	}
+	defer os.Remove(converted.Path)
+
+	blob, captionURL, err := cfg.storeCaptionBlob(r.Context(), video.ID, converted)
+	if err != nil {
+		respondWithStoreError(w, "Couldn't store captions", err)
+		return
+	}
+
+	old, err := cfg.db.GetVideoCaption(video.ID, language)
+	if err != nil {
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+		return
+	}
+
+	caption, err := cfg.db.UpsertVideoCaption(database.UpsertVideoCaptionParams{
+		VideoID:    video.ID,
+		Language:   language,
+		Label:      label,
+		URL:        captionURL,
+		SHA256:     blob.SHA256,
+		Storage:    blob.Storage,
+		DurationMS: duration.Milliseconds(),
+	})
+	if err != nil {
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save captions", err)
+		return
+	}
+
+	status := http.StatusCreated
+	if old.VideoID != uuid.Nil {
+		status = http.StatusOK
+		cfg.releaseBlob(old.Storage, &old.SHA256)
+	}
+	cfg.invalidateCaptionPlaylists(video)
+
+	respondWithJSON(w, status, caption)
+}
+
+func (cfg *apiConfig) handlerVideoCaptionsDelete(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	language, ok := canonicalLanguage(r.PathValue("language"))
+	if !ok {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidLanguage, "Language must be a BCP 47 tag such as en or pt-BR", nil)
+		return
+	}
+
+	caption, err := cfg.db.GetVideoCaption(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+		return
+	}
+	if caption.VideoID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video has no captions in that language", nil)
+		return
+	}
+
+	err = cfg.db.DeleteVideoCaption(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete captions", err)
+		return
+	}
+	cfg.releaseBlob(caption.Storage, &caption.SHA256)
+	cfg.invalidateCaptionPlaylists(video)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// invalidateCaptionPlaylists drops cached HLS playlists that list a video's
+// caption tracks. Only locally served playlists get caption renditions.
+func (cfg *apiConfig) invalidateCaptionPlaylists(video database.Video) {
+	if !cfg.usesLocalVideoStorage() {
+		return
+	}
+	cfg.invalidateCDN("captions changed for video "+video.ID.String(), video.VideoURL)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/chapters"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const errCodeInvalidChapters = "invalid_chapters"
+
+func (cfg *apiConfig) handlerVideoChaptersList(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForViewing(w, r)
+	if !ok {
+		return
+	}
+
+	videoChapters, err := cfg.db.GetVideoChapters(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videoChapters)
+}
+
+// handlerVideoChaptersSet replaces a video's chapters. From then on they
+// no longer follow the description, until they are deleted.
+func (cfg *apiConfig) handlerVideoChaptersSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Chapters []database.VideoChapter `json:"chapters"`
+	}
+
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	for i := range params.Chapters {
+		params.Chapters[i].Title = strings.TrimSpace(params.Chapters[i].Title)
+	}
+	err = chapters.Validate(toChapters(params.Chapters), videoDuration(video))
+	if errors.Is(err, chapters.ErrInvalid) {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidChapters, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate chapters", err)
+		return
+	}
+
+	err = cfg.db.SetVideoChapters(video.ID, params.Chapters, true)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chapters", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, params.Chapters)
+}
+
+// handlerVideoChaptersDelete drops chapters set through the API, so the
+// video goes back to the chapters in its description, if any.
+func (cfg *apiConfig) handlerVideoChaptersDelete(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	video.ChaptersManual = false
+	err := cfg.updateChapters(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chapters", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoChaptersVTT serves a video's chapters as a WebVTT chapters
+// track for <track kind="chapters">.
+func (cfg *apiConfig) handlerVideoChaptersVTT(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForViewing(w, r)
+	if !ok {
+		return
+	}
+
+	videoChapters, err := cfg.db.GetVideoChapters(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chapters", err)
+		return
+	}
+	if len(videoChapters) == 0 {
+		respondWithError(w, http.StatusNotFound, "Video has no chapters", nil)
+		return
+	}
+
+	vtt := chapters.ToWebVTT(toChapters(videoChapters), videoDuration(video))
+	cacheControl := "no-cache"
+	if video.IsPrivate {
+		cacheControl = "private, no-cache"
+	}
+	sum := sha256.Sum256(vtt)
+	w.Header().Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Content-Type", mediaContentTypes[".vtt"])
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, "chapters.vtt", time.Time{}, strings.NewReader(string(vtt)))
+}
+
+// updateChapters brings a video's chapters in line with its description
+// and duration after either changed. Chapters set through the API are
+// kept, less any that now start after the video ends.
+func (cfg *apiConfig) updateChapters(video database.Video) error {
+	duration := videoDuration(video)
+	if !video.ChaptersManual {
+		parsed := chapters.Parse(video.Description)
+		if chapters.Validate(parsed, duration) != nil {
+			parsed = nil
+		}
+		return cfg.db.SetVideoChapters(video.ID, fromChapters(parsed), false)
+	}
+
+	videoChapters, err := cfg.db.GetVideoChapters(video.ID)
+	if err != nil {
+		return err
+	}
+	if chapters.Validate(toChapters(videoChapters), duration) == nil {
+		return nil
+	}
+	kept := []database.VideoChapter{}
+	for _, chapter := range videoChapters {
+		if time.Duration(chapter.StartMS)*time.Millisecond < duration {
+			kept = append(kept, chapter)
+		}
+	}
+	if chapters.Validate(toChapters(kept), duration) != nil {
+		kept = nil
+	}
+	return cfg.db.SetVideoChapters(video.ID, kept, true)
+}
+
+// chaptersURL is where a video's WebVTT chapters track is served.
+func (cfg *apiConfig) chaptersURL(video database.Video) string {
+	return cfg.publicURL + "/api/videos/" + video.ID.String() + "/chapters.vtt"
+}
+
+// videoDuration is a video's probed length, or zero before a file has
+// been uploaded.
+func videoDuration(video database.Video) time.Duration {
+	if video.DurationMS == nil {
+		return 0
+	}
+	return time.Duration(*video.DurationMS) * time.Millisecond
+}
+
+func toChapters(videoChapters []database.VideoChapter) []chapters.Chapter {
+	out := make([]chapters.Chapter, 0, len(videoChapters))
+	for _, chapter := range videoChapters {
+		out = append(out, chapters.Chapter{Start: time.Duration(chapter.StartMS) * time.Millisecond, Title: chapter.Title})
+	}
+	return out
+}
+
+func fromChapters(parsed []chapters.Chapter) []database.VideoChapter {
+	out := make([]database.VideoChapter, 0, len(parsed))
+	for _, chapter := range parsed {
+		out = append(out, database.VideoChapter{StartMS: chapter.Start.Milliseconds(), Title: chapter.Title})
+	}
+	return out
+}
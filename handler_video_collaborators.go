@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoCollaboratorsList(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	collaborators, err := cfg.db.GetVideoCollaborators(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve collaborators", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, collaborators)
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorsAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	video, _, ok := cfg.videoForMutation(w, r, videoActionManage)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+	if user.ID == video.UserID {
+		respondWithError(w, http.StatusBadRequest, "The owner can't be a collaborator", nil)
+		return
+	}
+
+	err = cfg.db.AddVideoCollaborator(video.ID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add collaborator", err)
+		return
+	}
+
+	collaborators, err := cfg.db.GetVideoCollaborators(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve collaborators", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, collaborators)
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorsRemove(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionManage)
+	if !ok {
+		return
+	}
+
+	collaboratorID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	err = cfg.db.RemoveVideoCollaborator(video.ID, collaboratorID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove collaborator", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerVideoEditGet returns the segments a video was cut from, empty
+// when it hasn't been edited.
+func (cfg *apiConfig) handlerVideoEditGet(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	segments, err := cfg.db.GetVideoEditSegments(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve edit", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, segments)
+}
+
+// handlerVideoEdit trims a video, or splices segments of it together, and
+// publishes the result. Edits always start from the unedited video, so
+// they can be redone or undone without losing anything. start_ms and
+// end_ms are shorthand for a single segment.
+func (cfg *apiConfig) handlerVideoEdit(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Segments []database.VideoEditSegment `json:"segments"`
+		StartMS  *int64                      `json:"start_ms"`
+		EndMS    *int64                      `json:"end_ms"`
+	}
+
+	ctx := r.Context()
+
+	video, userID, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.StartMS != nil || params.EndMS != nil {
+		if len(params.Segments) > 0 || params.StartMS == nil || params.EndMS == nil {
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidEdit, "Send either segments or both start_ms and end_ms", nil)
+			return
+		}
+		params.Segments = []database.VideoEditSegment{{StartMS: *params.StartMS, EndMS: *params.EndMS}}
+	}
+
+	uneditedSHA256 := video.UneditedSHA256
+	if uneditedSHA256 == nil {
+		uneditedSHA256 = video.VideoSHA256
+	}
+	if uneditedSHA256 == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has no file to edit", nil)
+		return
+	}
+
+	uneditedPath, cleanup, err := cfg.fetchVideoBlob(ctx, *uneditedSHA256)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video", err)
+		return
+	}
+	defer cleanup()
+
+	probe, err := validateVideo(ctx, cfg.media, uneditedPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't probe video", err)
+		return
+	}
+
+	segments := make([]editSegment, 0, len(params.Segments))
+	for _, segment := range params.Segments {
+		segments = append(segments, editSegment{
+			Start: time.Duration(segment.StartMS) * time.Millisecond,
+			End:   time.Duration(segment.EndMS) * time.Millisecond,
+		})
+	}
+	err = validateEdit(segments, probe.Duration)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidEdit, err.Error(), err)
+		return
+	}
+
+	editedPath, err := cutVideo(ctx, cfg.media, uneditedPath, segments, probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't cut video", err)
+		return
+	}
+	defer os.Remove(editedPath)
+
+	faststartFilePath, err := processVideoForFastStart(ctx, cfg.media, editedPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to process the file", err)
+		return
+	}
+	defer os.Remove(faststartFilePath)
+
+	video, err = cfg.publishRendition(ctx, video, faststartFilePath, uneditedSHA256, params.Segments)
+	if err != nil {
+		if errors.Is(err, database.ErrVideoChanged) {
+			respondWithUpdateError(w, "Couldn't publish edited video", err)
+			return
+		}
+		respondWithStoreError(w, "Couldn't publish edited video", err)
+		return
+	}
+
+	video, err = cfg.videoForViewer(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoEditUndo puts the unedited video back.
+func (cfg *apiConfig) handlerVideoEditUndo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	video, userID, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+	if !video.Edited || video.UneditedSHA256 == nil {
+		respondWithError(w, http.StatusNotFound, "Video hasn't been edited", nil)
+		return
+	}
+
+	uneditedPath, cleanup, err := cfg.fetchVideoBlob(ctx, *video.UneditedSHA256)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video", err)
+		return
+	}
+	defer cleanup()
+
+	video, err = cfg.publishRendition(ctx, video, uneditedPath, nil, nil)
+	if err != nil {
+		if errors.Is(err, database.ErrVideoChanged) {
+			respondWithUpdateError(w, "Couldn't restore unedited video", err)
+			return
+		}
+		respondWithStoreError(w, "Couldn't restore unedited video", err)
+		return
+	}
+
+	video, err = cfg.videoForViewer(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// publishRendition makes the MP4 at videoPath the video's published file,
+// with new storyboard, preview and loudness, and saves it. uneditedSHA256 and
+// segments record the edit it was cut with; both are nil for the
+// unedited video. Auto-generated captions are dropped and transcribed
+// again to fit.
+func (cfg *apiConfig) publishRendition(ctx context.Context, video database.Video, videoPath string, uneditedSHA256 *string, segments []database.VideoEditSegment) (database.Video, error) {
+	probe, err := validateVideo(ctx, cfg.media, videoPath)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't probe rendition: %w", err)
+	}
+
+	// Cutting changes the integrated loudness, so it's measured again, and
+	// a cut of a normalized video is normalized again if it drifted; the
+	// unedited file was normalized on upload. Measuring is best effort.
+	var loudness *float64
+	loudnessNormalized := false
+	if probe.Audio != nil {
+		measured, err := measureLoudness(ctx, cfg.media, videoPath, cfg.loudnessTarget)
+		switch {
+		case errors.Is(err, errSilentAudio):
+		case err != nil:
+			log.Printf("Couldn't measure loudness for video %s: %v", video.ID, err)
+		default:
+			loudness = &measured.IntegratedLUFS
+			if video.LoudnessNormalized && uneditedSHA256 != nil && needsNormalizing(measured, cfg.loudnessTarget) {
+				normalizedPath, err := normalizeLoudness(ctx, cfg.media, videoPath, measured, cfg.loudnessTarget)
+				if err != nil {
+					return database.Video{}, fmt.Errorf("couldn't normalize loudness: %w", err)
+				}
+				defer os.Remove(normalizedPath)
+				videoPath, err = processVideoForFastStart(ctx, cfg.media, normalizedPath)
+				if err != nil {
+					return database.Video{}, err
+				}
+				defer os.Remove(videoPath)
+			}
+			loudnessNormalized = video.LoudnessNormalized
+		}
+	}
+
+	processed, err := hashFile(videoPath)
+	if err != nil {
+		return database.Video{}, err
+	}
+	prefix := aspectRatioToPrefix[getVideoAspectRatio(probe.Video.Width, probe.Video.Height)]
+	blob, videoURL, err := cfg.storeVideoBlob(ctx, video.ID, processed, prefix, ".mp4", "video/mp4")
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	assets := cfg.makeVideoAssets(ctx, video.ID, videoPath, probe)
+
+	oldSheets, err := cfg.db.GetVideoStoryboardSheets(video.ID)
+	if err != nil {
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		cfg.releaseVideoAssets(assets)
+		return database.Video{}, err
+	}
+
+	oldVideo := video
+	video.VideoURL = &videoURL
+	video.VideoSHA256 = &blob.SHA256
+	video.Edited, video.UneditedSHA256 = uneditedSHA256 != nil, uneditedSHA256
+	video.LoudnessLUFS, video.LoudnessNormalized = loudness, loudnessNormalized
+	sheetSHA256s := cfg.setVideoAssets(&video, assets, probe)
+	err = cfg.db.UpdateVideo(video, oldVideo.VideoSHA256)
+	if err != nil {
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		cfg.releaseVideoAssets(assets)
+		return database.Video{}, err
+	}
+	cfg.finishVideoAssets(video, oldVideo, sheetSHA256s, oldSheets)
+	err = cfg.db.SetVideoEditSegments(video.ID, segments)
+	if err != nil {
+		log.Printf("Couldn't save edit of video %s: %v", video.ID, err)
+	}
+
+	// On a first edit, the reference to the published file moves over to
+	// the unedited one rather than being dropped.
+	if oldVideo.UneditedSHA256 != nil || uneditedSHA256 == nil {
+		cfg.releaseBlob(cfg.videoBlobStorage(), oldVideo.VideoSHA256)
+	}
+	if uneditedSHA256 == nil {
+		cfg.releaseBlob(cfg.videoBlobStorage(), oldVideo.UneditedSHA256)
+	}
+	if oldVideo.VideoURL == nil || *oldVideo.VideoURL != videoURL {
+		cfg.invalidateCDN("video edited for video "+video.ID.String(), oldVideo.VideoURL)
+	}
+	cfg.dropAutoCaptions(video)
+	if probe.Audio != nil {
+		cfg.requestTranscription(video.ID)
+	}
+	return video, nil
+}
@@ -2,32 +2,37 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
+// respondWithUpdateError responds to a failed UpdateVideo, with 409 when
+// another request replaced the video's file first.
+func respondWithUpdateError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, database.ErrVideoChanged) {
+		respondWithError(w, http.StatusConflict, "Video was changed by another request, try again", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, msg, err)
+}
+
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
@@ -39,45 +44,147 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	err = cfg.updateChapters(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chapters", err)
+		return
+	}
+
+	video, err = cfg.videoForViewer(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
-func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		IsPrivate   *bool   `json:"is_private"`
+	}
+
+	video, userID, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	if params.Title != nil {
+		if *params.Title == "" {
+			respondWithError(w, http.StatusBadRequest, "Title can't be empty", nil)
+			return
+		}
+		video.Title = *params.Title
+	}
+	descriptionChanged := false
+	if params.Description != nil {
+		descriptionChanged = *params.Description != video.Description
+		video.Description = *params.Description
+	}
+	madePrivate := false
+	if params.IsPrivate != nil {
+		madePrivate = *params.IsPrivate && !video.IsPrivate
+		video.IsPrivate = *params.IsPrivate
+	}
+	oldVideo, oldSheets := video, []string(nil)
+	var publicCaptions []database.VideoCaption
+	var publicSheets []string
+	if madePrivate {
+		publicCaptions, err = cfg.db.GetVideoCaptions(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+			return
+		}
+		publicSheets, err = cfg.db.GetVideoStoryboardSheets(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storyboard", err)
+			return
+		}
+		oldVideo, oldSheets, err = cfg.dropPublicRenditions(&video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storyboard", err)
+			return
+		}
+	}
+
+	err = cfg.db.UpdateVideo(video, video.VideoSHA256)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithUpdateError(w, "Couldn't update video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	cfg.releasePublicRenditions(video, oldVideo, oldSheets)
+	if descriptionChanged {
+		err = cfg.updateChapters(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update chapters", err)
+			return
+		}
+	}
+	if madePrivate {
+		// Copies cached while the video was public would stay watchable.
+		cfg.invalidateCDN("video "+video.ID.String()+" made private", cfg.videoURLs(oldVideo, publicCaptions, publicSheets)...)
+	}
+
+	video, err = cfg.videoForViewer(video, userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionManage)
+	if !ok {
+		return
+	}
+
+	videoCaptions, err := cfg.db.GetVideoCaptions(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+
+	storyboardSheets, err := cfg.db.GetVideoStoryboardSheets(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storyboard", err)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.db.DeleteVideo(video.ID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
 
+	err = cfg.deleteLocalVideo(video.ID)
+	if err != nil {
+		log.Printf("Couldn't delete files for video %s: %v", video.ID, err)
+	}
+	cfg.releaseBlob(cfg.videoBlobStorage(), video.VideoSHA256)
+	cfg.releaseBlob(blobStorageAssets, video.ThumbnailSHA256)
+	cfg.releaseBlob(cfg.videoBlobStorage(), video.SourceSHA256)
+	cfg.releaseBlob(cfg.videoBlobStorage(), video.OriginalSHA256)
+	cfg.releaseBlob(cfg.videoBlobStorage(), video.UneditedSHA256)
+	for _, caption := range videoCaptions {
+		cfg.releaseBlob(caption.Storage, &caption.SHA256)
+	}
+	cfg.releaseVideoStoryboard(video, storyboardSheets)
+	cfg.releaseVideoPreview(video)
+	cfg.releaseBlob(cfg.videoBlobStorage(), video.AudioSHA256)
+	cfg.invalidateCDN("video "+video.ID.String()+" deleted", cfg.videoURLs(video, videoCaptions, storyboardSheets)...)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -94,6 +201,23 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+
+	// Private videos are reported as missing to anyone who can't see them.
+	viewerID := cfg.optionalUser(r)
+	canView, err := cfg.canViewVideo(video, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't authorize video access", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	video, err = cfg.videoForViewer(video, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
 	// video, err = cfg.dbVideoToSignedVideo(video)
 	// if err != nil {
 	// 	respondWithError(w, http.StatusInternalServerError, "Error in dbVideoToSignedVideo in handlerVideoGet", err)
@@ -103,14 +227,8 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
 		return
 	}
 
@@ -119,6 +237,13 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	for i := range videos {
+		videos[i], err = cfg.videoForViewer(videos[i], userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+			return
+		}
+	}
 
 	// videosNew := []database.Video{}
 	// for i := range len(videos) {
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoStream serves a locally stored video file, either an mp4 or
+// an HLS playlist or segment, with range support. Private videos need an
+// access token or the stream token from their video_url.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	if !cfg.usesLocalVideoStorage() {
+		respondWithError(w, http.StatusNotFound, "Videos are not stored locally", nil)
+		return
+	}
+
+	fileName := r.PathValue("file")
+	if fileName == "" || strings.HasPrefix(fileName, ".") || filepath.Base(fileName) != fileName {
+		respondWithError(w, http.StatusBadRequest, "Invalid file name", nil)
+		return
+	}
+
+	// Access is rechecked on every request so revoking a collaborator or
+	// making a video private takes effect for outstanding stream tokens.
+	video, _, ok := cfg.videoForViewing(w, r)
+	if !ok {
+		return
+	}
+	streamToken := r.URL.Query().Get("token")
+
+	if path.Ext(fileName) == ".m3u8" {
+		cfg.servePlaylist(w, r, video, fileName, streamToken)
+		return
+	}
+
+	name := path.Join(video.ID.String(), fileName)
+	sha := strings.TrimSuffix(fileName, path.Ext(fileName))
+	switch {
+	case video.VideoSHA256 != nil && sha == *video.VideoSHA256:
+		name = path.Join(localBlobDir, fileName)
+	case video.AudioSHA256 != nil && sha == *video.AudioSHA256:
+		name = path.Join(localBlobDir, fileName)
+	case video.PreviewSHA256 != nil && sha == *video.PreviewSHA256:
+		name = path.Join(localBlobDir, fileName)
+	case video.PreviewMP4SHA256 != nil && sha == *video.PreviewMP4SHA256:
+		name = path.Join(localBlobDir, fileName)
+	case video.SourceSHA256 != nil && sha == *video.SourceSHA256:
+		name = path.Join(localBlobDir, fileName)
+		// The original upload is offered as a download, not for playback.
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": video.Title + path.Ext(fileName),
+		}))
+	case video.StoryboardSHA256 != nil && sha == *video.StoryboardSHA256:
+		if video.IsPrivate && streamToken != "" {
+			cfg.serveStoryboardIndex(w, r, fileName, streamToken)
+			return
+		}
+		name = path.Join(localBlobDir, fileName)
+	case path.Ext(fileName) == ".jpg":
+		sheets, err := cfg.db.GetVideoStoryboardSheets(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storyboard", err)
+			return
+		}
+		if slices.Contains(sheets, sha) {
+			name = path.Join(localBlobDir, fileName)
+		}
+	case path.Ext(fileName) == ".vtt":
+		videoCaptions, err := cfg.db.GetVideoCaptions(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+			return
+		}
+		for _, caption := range videoCaptions {
+			if sha == caption.SHA256 {
+				name = path.Join(localBlobDir, fileName)
+				break
+			}
+		}
+	}
+	if !video.IsPrivate {
+		cfg.videoFiles.serveFile(w, r, name, "")
+		return
+	}
+	cfg.videoFiles.serveFile(w, r, name, "private, no-cache")
+}
+
+// serveStoryboardIndex serves a private video's storyboard index with the
+// stream token added to its sheet URLs.
+func (cfg *apiConfig) serveStoryboardIndex(w http.ResponseWriter, r *http.Request, fileName, streamToken string) {
+	data, err := os.ReadFile(filepath.Join(cfg.videosRoot, localBlobDir, fileName))
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read storyboard", err)
+		return
+	}
+	index := tokenizeStoryboardIndex(string(data), streamToken)
+
+	sum := sha256.Sum256([]byte(index))
+	w.Header().Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Content-Type", mediaContentTypes[".vtt"])
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, fileName, time.Time{}, strings.NewReader(index))
+}
+
+// servePlaylist serves an HLS playlist for a video. Master playlists get
+// the video's caption tracks as subtitle renditions, and private playlists
+// requested with a stream token get it added to their URIs.
+func (cfg *apiConfig) servePlaylist(w http.ResponseWriter, r *http.Request, video database.Video, fileName, streamToken string) {
+	var playlist string
+	if language, ok := captionPlaylistLanguage(fileName); ok {
+		caption, err := cfg.db.GetVideoCaption(video.ID, language)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+			return
+		}
+		if caption.VideoID == uuid.Nil {
+			http.NotFound(w, r)
+			return
+		}
+		// Captions served alongside the playlist are referenced relatively,
+		// so a stream token gets added to them like to segments.
+		if captionPath := "/api/videos/" + video.ID.String() + "/stream/"; strings.Contains(caption.URL, captionPath) {
+			caption.URL = path.Base(caption.URL)
+		}
+		playlist = captionPlaylist(caption)
+	} else {
+		data, err := os.ReadFile(filepath.Join(cfg.localVideoDir(video.ID), fileName))
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't read playlist", err)
+			return
+		}
+		playlist = string(data)
+		if isMasterPlaylist(playlist) {
+			videoCaptions, err := cfg.db.GetVideoCaptions(video.ID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+				return
+			}
+			playlist = addSubtitleRenditions(playlist, videoCaptions)
+		}
+	}
+
+	cacheControl := "no-cache"
+	if video.IsPrivate {
+		cacheControl = "private, no-cache"
+		if streamToken != "" {
+			playlist = tokenizePlaylist(playlist, streamToken)
+			cacheControl = "private, no-store"
+		}
+	}
+
+	sum := sha256.Sum256([]byte(playlist))
+	w.Header().Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Content-Type", mediaContentTypes[".m3u8"])
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, fileName, time.Time{}, strings.NewReader(playlist))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveStream fetches rawURL, as handed to a client, from the stream
+// handler.
+func serveStream(t *testing.T, cfg *apiConfig, rawURL string) *httptest.ResponseRecorder {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	dir, file := path.Split(u.Path)
+	r := httptest.NewRequest("GET", u.RequestURI(), nil)
+	r.SetPathValue("videoID", path.Base(path.Dir(path.Clean(dir))))
+	r.SetPathValue("file", file)
+	rr := httptest.NewRecorder()
+	cfg.handlerVideoStream(rr, r)
+	return rr
+}
+
+func TestPrivateRenditionsNeedAccess(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.storyboardInterval = 10 * time.Second
+	cfg.previewLength = 4 * time.Second
+	video := newPublishedTestVideo(t, cfg, "upload")
+	video.IsPrivate = true
+	err := cfg.db.UpdateVideo(video, video.VideoSHA256)
+	if err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	video, err = cfg.publishRendition(context.Background(), video, writeTestFile(t, "video.mp4", "rendition"), nil, nil)
+	if err != nil {
+		t.Fatalf("publishRendition: %v", err)
+	}
+	if video.StoryboardURL == nil || video.PreviewURL == nil || video.PreviewMP4URL == nil {
+		t.Fatalf("video = %+v, want a storyboard and preview", video)
+	}
+	if files := listFiles(t, cfg.assetsRoot); len(files) > 0 {
+		t.Errorf("renditions stored as public assets %v", files)
+	}
+	viewed, err := cfg.videoForViewer(video, video.UserID)
+	if err != nil {
+		t.Fatalf("videoForViewer: %v", err)
+	}
+
+	for _, tt := range []struct{ name, url, tokenized string }{
+		{"preview", *video.PreviewURL, *viewed.PreviewURL},
+		{"preview MP4", *video.PreviewMP4URL, *viewed.PreviewMP4URL},
+	} {
+		if rr := serveStream(t, cfg, tt.url); rr.Code != http.StatusNotFound {
+			t.Errorf("%s without a token: status = %d, want %d", tt.name, rr.Code, http.StatusNotFound)
+		}
+		if rr := serveStream(t, cfg, tt.tokenized); rr.Code != http.StatusOK {
+			t.Errorf("%s with a token: status = %d, want %d: %s", tt.name, rr.Code, http.StatusOK, rr.Body)
+		}
+	}
+
+	rr := serveStream(t, cfg, *video.StoryboardURL)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("storyboard without a token: status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	rr = serveStream(t, cfg, *viewed.StoryboardURL)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("storyboard with a token: status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	// Sheets are fetched by the player, which only has the URLs in the
+	// index to go on.
+	var sheetURL string
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		if before, _, ok := strings.Cut(line, "#xywh="); ok {
+			sheetURL = before
+			break
+		}
+	}
+	if !strings.Contains(sheetURL, "?token=") {
+		t.Fatalf("index points at sheet %q, want it with the stream token", sheetURL)
+	}
+	storyboardURL, _ := url.Parse(*viewed.StoryboardURL)
+	sheet, err := storyboardURL.Parse(sheetURL)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	rr = serveStream(t, cfg, sheet.String())
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("sheet: status = %d, type %s, want a JPEG", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestMadePrivateDropsPublicPreview(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner, token := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, owner)
+	// Older versions stored previews as public assets.
+	f, err := hashFile(writeTestFile(t, "preview.webp", "preview"))
+	if err != nil {
+		t.Fatalf("hashFile: %v", err)
+	}
+	blob, err := cfg.storeBlob(context.Background(), blobStorageAssets, f, "", ".webp", "image/webp")
+	if err != nil {
+		t.Fatalf("storeBlob: %v", err)
+	}
+	previewURL := cfg.publicURL + "/assets/" + blob.Key
+	video.PreviewURL, video.PreviewSHA256 = &previewURL, &blob.SHA256
+	err = cfg.db.UpdateVideo(video, nil)
+	if err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+
+	h := mutationHandler{
+		handler: func(cfg *apiConfig) http.HandlerFunc { return cfg.handlerVideoMetaUpdate },
+		newRequest: func(t *testing.T, videoID string) *http.Request {
+			return httptest.NewRequest("PUT", "/api/videos/"+videoID, strings.NewReader(`{"is_private": true}`))
+		},
+	}
+	rr := serveMutation(t, cfg, h, video.ID.String(), token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if stored.PreviewURL != nil || stored.PreviewSHA256 != nil {
+		t.Errorf("private video kept its public preview %s", *stored.PreviewURL)
+	}
+	released, err := cfg.db.GetBlob(blobStorageAssets, blob.SHA256)
+	if err != nil {
+		t.Fatalf("GetBlob: %v", err)
+	}
+	if released.RefCount != 0 {
+		t.Errorf("public preview still has %d references", released.RefCount)
+	}
+}
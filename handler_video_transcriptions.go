@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
+)
+
+const errCodeTranscriptionDisabled = "transcription_disabled"
+
+func (cfg *apiConfig) handlerVideoTranscriptionsList(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+
+	jobs, err := cfg.db.GetTranscriptionJobs(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve transcriptions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, jobs)
+}
+
+// handlerVideoTranscriptionsCreate queues automatic captions for a video,
+// e.g. to retry a failed transcription or to ask for a specific language
+// when detection got it wrong.
+func (cfg *apiConfig) handlerVideoTranscriptionsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Language string `json:"language"`
+	}
+
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+	if cfg.transcriber == nil {
+		respondWithErrorCode(w, http.StatusServiceUnavailable, errCodeTranscriptionDisabled, "Automatic captions aren't enabled on this server", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has no file to transcribe", nil)
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	language := cfg.transcribeLanguage
+	if params.Language != "" && params.Language != transcribe.AutoDetect {
+		language, ok = canonicalLanguage(params.Language)
+		if !ok {
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidLanguage, "Language must be a BCP 47 tag such as en or pt-BR", nil)
+			return
+		}
+	} else if params.Language == transcribe.AutoDetect {
+		language = transcribe.AutoDetect
+	}
+
+	job, err := cfg.db.CreateTranscriptionJob(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue transcription", err)
+		return
+	}
+	cfg.wakeTranscriptions()
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
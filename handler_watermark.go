@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxWatermarkUploadSize = 10 << 20
+	// originalDownloadTTL is how long the S3 link to an original works.
+	originalDownloadTTL = 15 * time.Minute
+)
+
+// watermarkSettingsFromForm applies the position, opacity and margin form
+// fields that are present to wm.
+func watermarkSettingsFromForm(r *http.Request, wm watermark) (watermark, error) {
+	if position := r.FormValue("position"); position != "" {
+		wm.Position = position
+	}
+	if opacityString := r.FormValue("opacity"); opacityString != "" {
+		opacity, err := strconv.ParseFloat(opacityString, 64)
+		if err != nil {
+			return watermark{}, errors.New("opacity must be a number")
+		}
+		wm.Opacity = opacity
+	}
+	if marginString := r.FormValue("margin"); marginString != "" {
+		margin, err := strconv.Atoi(marginString)
+		if err != nil {
+			return watermark{}, errors.New("margin must be a whole number of pixels")
+		}
+		wm.Margin = margin
+	}
+	return wm, wm.validate()
+}
+
+// receiveWatermarkImage reads the optional "image" form file into a
+// validated temporary file. ok is false once an error response has been
+// written; upload.Path is empty when no image was sent.
+func receiveWatermarkImage(w http.ResponseWriter, r *http.Request) (hashedFile, string, bool) {
+	file, fileHeader, err := r.FormFile("image")
+	if errors.Is(err, http.ErrMissingFile) {
+		return hashedFile{}, "", true
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read watermark image", err)
+		return hashedFile{}, "", false
+	}
+	defer file.Close()
+
+	upload, ok := receiveUpload(w, r, fileHeader.Header, file, "tubely-watermark")
+	if !ok {
+		return hashedFile{}, "", false
+	}
+	mediaType, err := checkMediaType(fileHeader.Header.Get("Content-Type"), upload.Path, thumbnailMediaTypes)
+	if err == nil {
+		err = validateImage(upload.Path)
+	}
+	if err != nil {
+		os.Remove(upload.Path)
+		respondWithMediaError(w, err)
+		return hashedFile{}, "", false
+	}
+	return upload, mediaType, true
+}
+
+func (cfg *apiConfig) handlerWatermarkGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	userWatermark, err := cfg.db.GetUserWatermark(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve watermark", err)
+		return
+	}
+	if userWatermark.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "You haven't set a watermark", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userWatermark)
+}
+
+// handlerWatermarkSet sets the watermark stamped on the caller's future
+// uploads. The image may be left out to change only the placement.
+func (cfg *apiConfig) handlerWatermarkSet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWatermarkUploadSize)
+	err := r.ParseMultipartForm(maxWatermarkUploadSize)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse multipart form", err)
+		return
+	}
+
+	old, err := cfg.db.GetUserWatermark(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve watermark", err)
+		return
+	}
+	settings := watermark{Position: defaultWatermarkPosition, Opacity: defaultWatermarkOpacity, Margin: defaultWatermarkMargin}
+	if old.UserID != uuid.Nil {
+		settings = watermark{Position: old.Position, Opacity: old.Opacity, Margin: old.Margin}
+	}
+	settings, err = watermarkSettingsFromForm(r, settings)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidWatermark, err.Error(), err)
+		return
+	}
+
+	upload, mediaType, ok := receiveWatermarkImage(w, r)
+	if !ok {
+		return
+	}
+	imageURL, imageSHA256 := old.ImageURL, old.ImageSHA256
+	if upload.Path != "" {
+		defer os.Remove(upload.Path)
+		blob, err := cfg.storeBlob(r.Context(), blobStorageAssets, upload, "", "."+path.Base(mediaType), mediaType)
+		if err != nil {
+			respondWithStoreError(w, "Couldn't store watermark image", err)
+			return
+		}
+		imageURL, imageSHA256 = cfg.publicURL+"/assets/"+blob.Key, blob.SHA256
+	} else if old.UserID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Missing watermark image", nil)
+		return
+	}
+
+	userWatermark, err := cfg.db.UpsertUserWatermark(database.UpsertUserWatermarkParams{
+		UserID:      userID,
+		ImageURL:    imageURL,
+		ImageSHA256: imageSHA256,
+		Position:    settings.Position,
+		Opacity:     settings.Opacity,
+		Margin:      settings.Margin,
+	})
+	if err != nil {
+		if upload.Path != "" {
+			cfg.releaseBlob(blobStorageAssets, &imageSHA256)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save watermark", err)
+		return
+	}
+
+	status := http.StatusCreated
+	if old.UserID != uuid.Nil {
+		status = http.StatusOK
+		if upload.Path != "" {
+			cfg.releaseBlob(blobStorageAssets, &old.ImageSHA256)
+		}
+	}
+	respondWithJSON(w, status, userWatermark)
+}
+
+// handlerWatermarkDelete removes the caller's watermark, so their uploads
+// get the deployment's again, if it has one.
+func (cfg *apiConfig) handlerWatermarkDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	userWatermark, err := cfg.db.GetUserWatermark(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve watermark", err)
+		return
+	}
+	if userWatermark.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "You haven't set a watermark", nil)
+		return
+	}
+
+	err = cfg.db.DeleteUserWatermark(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete watermark", err)
+		return
+	}
+	cfg.releaseBlob(blobStorageAssets, &userWatermark.ImageSHA256)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWatermarkPreview renders one frame of a video with a watermark as
+// a JPEG, without changing anything. It takes the same form as
+// handlerWatermarkSet plus "at", the offset in seconds; anything left out
+// comes from the watermark the video's uploads get.
+func (cfg *apiConfig) handlerWatermarkPreview(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+	sourceSHA256 := video.OriginalSHA256
+	if sourceSHA256 == nil {
+		sourceSHA256 = video.VideoSHA256
+	}
+	if sourceSHA256 == nil {
+		respondWithError(w, http.StatusBadRequest, "Video has no file to preview", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWatermarkUploadSize)
+	err := r.ParseMultipartForm(maxWatermarkUploadSize)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse multipart form", err)
+		return
+	}
+
+	wm, found, err := cfg.watermarkFor(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve watermark", err)
+		return
+	}
+	if !found {
+		wm = watermark{Position: defaultWatermarkPosition, Opacity: defaultWatermarkOpacity, Margin: defaultWatermarkMargin}
+	}
+	wm, err = watermarkSettingsFromForm(r, wm)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidWatermark, err.Error(), err)
+		return
+	}
+
+	upload, _, ok := receiveWatermarkImage(w, r)
+	if !ok {
+		return
+	}
+	if upload.Path != "" {
+		defer os.Remove(upload.Path)
+		wm.ImagePath = upload.Path
+	}
+	if wm.ImagePath == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing watermark image", nil)
+		return
+	}
+
+	duration := videoDuration(video)
+	offset := duration / 10
+	if atString := r.FormValue("at"); atString != "" {
+		at, err := strconv.ParseFloat(atString, 64)
+		if err != nil || at < 0 || (duration > 0 && time.Duration(at*float64(time.Second)) >= duration) {
+			respondWithError(w, http.StatusBadRequest, "at must be a time in seconds within the video", err)
+			return
+		}
+		offset = time.Duration(at * float64(time.Second))
+	}
+
+	videoPath, cleanup, err := cfg.fetchVideoBlob(r.Context(), *sourceSHA256)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video", err)
+		return
+	}
+	defer cleanup()
+
+	framePath, err := renderWatermarkPreview(r.Context(), cfg.media, videoPath, offset, wm)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't render preview", err)
+		return
+	}
+	defer os.Remove(framePath)
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeFile(w, r, framePath)
+}
+
+// handlerVideoOriginal downloads the un-watermarked upload of a
+// watermarked video. Only editors may fetch it.
+func (cfg *apiConfig) handlerVideoOriginal(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.videoForMutation(w, r, videoActionEdit)
+	if !ok {
+		return
+	}
+	if video.OriginalSHA256 == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no separate original", nil)
+		return
+	}
+
+	blob, err := cfg.db.GetBlob(cfg.videoBlobStorage(), *video.OriginalSHA256)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve original", err)
+		return
+	}
+	if blob.SHA256 == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no separate original", nil)
+		return
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": video.Title + path.Ext(blob.Key),
+	})
+	if blob.Storage != blobStorageS3 {
+		w.Header().Set("Content-Disposition", disposition)
+		cfg.videoFiles.serveFile(w, r, path.Join(localBlobDir, blob.Key), "private, no-store")
+		return
+	}
+
+	req, err := s3.NewPresignClient(cfg.s3Client).PresignGetObject(r.Context(), &s3.GetObjectInput{
+		Bucket:                     aws.String(cfg.s3Bucket),
+		Key:                        aws.String(blob.Key),
+		ResponseContentDisposition: aws.String(disposition),
+	}, s3.WithPresignExpires(originalDownloadTTL))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign download URL", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.Redirect(w, r, req.URL, http.StatusFound)
+}
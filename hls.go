@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// captionsGroupID names the subtitle group caption tracks are added to, so
+// it doesn't collide with subtitles packaged into the playlist itself.
+const captionsGroupID = "tubely-captions"
+
+var playlistURIAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// playlistAttrReplacer keeps labels from breaking out of a quoted
+// attribute value.
+var playlistAttrReplacer = strings.NewReplacer(`"`, "'", "\n", " ", "\r", " ")
+
+// isMasterPlaylist reports whether an HLS playlist lists variant streams
+// rather than media segments.
+func isMasterPlaylist(playlist string) bool {
+	return strings.Contains(playlist, "#EXT-X-STREAM-INF:")
+}
+
+// captionPlaylistName is the subtitle media playlist for a caption track,
+// served next to the video's own playlists.
+func captionPlaylistName(language string) string {
+	return "captions-" + language + ".m3u8"
+}
+
+// captionPlaylistLanguage is the inverse of captionPlaylistName.
+func captionPlaylistLanguage(fileName string) (string, bool) {
+	language, ok := strings.CutPrefix(fileName, "captions-")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(language, ".m3u8")
+}
+
+// addSubtitleRenditions adds caption tracks to a master playlist as
+// subtitle renditions and points every variant stream at them.
+func addSubtitleRenditions(playlist string, captions []database.VideoCaption) string {
+	if len(captions) == 0 {
+		return playlist
+	}
+
+	renditions := make([]string, 0, len(captions))
+	for _, caption := range captions {
+		renditions = append(renditions, fmt.Sprintf(
+			`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="%s",NAME="%s",LANGUAGE="%s",DEFAULT=NO,AUTOSELECT=YES,URI="%s"`,
+			captionsGroupID, playlistAttrReplacer.Replace(caption.Label), caption.Language, captionPlaylistName(caption.Language),
+		))
+	}
+
+	lines := strings.Split(playlist, "\n")
+	out := make([]string, 0, len(lines)+len(renditions))
+	added := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			if !added {
+				out = append(out, renditions...)
+				added = true
+			}
+			// A variant can only use one subtitle group; keep its own.
+			if !strings.Contains(line, "SUBTITLES=") {
+				line += `,SUBTITLES="` + captionsGroupID + `"`
+			}
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// captionPlaylist is a subtitle media playlist with the whole WebVTT file
+// as its single segment.
+func captionPlaylist(caption database.VideoCaption) string {
+	seconds := math.Max(float64(caption.DurationMS)/1000, 1)
+	return fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:%.3f,\n%s\n#EXT-X-ENDLIST\n",
+		int(math.Ceil(seconds)), seconds, caption.URL)
+}
+
+// tokenizePlaylist adds the stream token to every relative URI in a
+// playlist. Players resolve segment URIs against the playlist URL without
+// its query string, so the token would be lost.
+func tokenizePlaylist(playlist, streamToken string) string {
+	withToken := func(uri string) string {
+		if uri == "" || strings.Contains(uri, "://") || strings.HasPrefix(uri, "/") {
+			return uri
+		}
+		separator := "?"
+		if strings.Contains(uri, "?") {
+			separator = "&"
+		}
+		return uri + separator + "token=" + url.QueryEscape(streamToken)
+	}
+
+	lines := strings.Split(playlist, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#"):
+			lines[i] = playlistURIAttr.ReplaceAllStringFunc(line, func(attr string) string {
+				uri := playlistURIAttr.FindStringSubmatch(attr)[1]
+				return `URI="` + withToken(uri) + `"`
+			})
+		default:
+			lines[i] = withToken(trimmed)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
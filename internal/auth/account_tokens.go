@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MakeAccountToken signs a token for an account action such as email
+// verification or password reset. tokenID must be recorded by the caller
+// so the token can be consumed exactly once.
+func MakeAccountToken(
+	userID uuid.UUID,
+	tokenType TokenType,
+	tokenID uuid.UUID,
+	keys *KeyRing,
+	expiresIn time.Duration,
+) (string, error) {
+	return makeToken(tokenType, userID, tokenID.String(), keys, expiresIn)
+}
+
+// ValidateAccountToken checks an account action token and returns the user
+// and token IDs it was issued for.
+func ValidateAccountToken(tokenString string, tokenType TokenType, keys *KeyRing) (uuid.UUID, uuid.UUID, error) {
+	userID, tokenIDString, err := parseToken(tokenString, tokenType, keys)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	tokenID, err := uuid.Parse(tokenIDString)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("invalid token ID")
+	}
+	return userID, tokenID, nil
+}
@@ -17,7 +17,11 @@ import (
 type TokenType string
 
 const (
-	TokenTypeAccess TokenType = "tubely-access"
+	TokenTypeAccess            TokenType = "tubely-access"
+	TokenTypeEmailVerification TokenType = "tubely-email-verification"
+	TokenTypePasswordReset     TokenType = "tubely-password-reset"
+	TokenTypeLoginChallenge    TokenType = "tubely-login-challenge"
+	TokenTypeStream            TokenType = "tubely-stream"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -34,50 +38,97 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// MakeJWT issues an access token. sessionID ties it to the refresh token it
+// was issued alongside and is carried as the jti claim.
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	sessionID uuid.UUID,
+	keys *KeyRing,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
+	tokenID := ""
+	if sessionID != uuid.Nil {
+		tokenID = sessionID.String()
+	}
+	return makeToken(TokenTypeAccess, userID, tokenID, keys, expiresIn)
+}
+
+func ValidateJWT(tokenString string, keys *KeyRing) (uuid.UUID, error) {
+	userID, _, err := ValidateJWTSession(tokenString, keys)
+	return userID, err
+}
+
+// ValidateJWTSession validates an access token and also returns the
+// session it belongs to, or uuid.Nil for tokens issued without one.
+func ValidateJWTSession(tokenString string, keys *KeyRing) (uuid.UUID, uuid.UUID, error) {
+	userID, tokenID, err := parseToken(tokenString, TokenTypeAccess, keys)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if tokenID == "" {
+		return userID, uuid.Nil, nil
+	}
+	sessionID, err := uuid.Parse(tokenID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("invalid session ID: %w", err)
+	}
+	return userID, sessionID, nil
+}
+
+// makeToken signs a token of the given type with the key ring's active key.
+// tokenID is optional and lets single-use tokens be tracked server-side.
+func makeToken(
+	tokenType TokenType,
+	userID uuid.UUID,
+	tokenID string,
+	keys *KeyRing,
+	expiresIn time.Duration,
+) (string, error) {
+	key := keys.ActiveKey()
+	token := jwt.NewWithClaims(key.Method, jwt.RegisteredClaims{
+		Issuer:    string(tokenType),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
+		ID:        tokenID,
 	})
-	return token.SignedString(signingKey)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Signer)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+// parseToken verifies the token's signature and expiry and that it was
+// issued as tokenType, so a token minted for one purpose can't be replayed
+// for another.
+func parseToken(tokenString string, tokenType TokenType, keys *KeyRing) (uuid.UUID, string, error) {
 	claimsStruct := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, "", err
 	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+	if issuer != string(tokenType) {
+		return uuid.Nil, "", errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return uuid.Nil, "", fmt.Errorf("invalid user ID: %w", err)
 	}
-	return id, nil
+	return id, claimsStruct.ID, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyFileExt = ".pem"
+
+// SigningKey is a private key used to sign access tokens. Its ID is
+// published as the `kid` header so verifiers can pick the matching key.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Signer crypto.Signer
+}
+
+// KeyRing holds every key that access tokens may be verified with and
+// names the one new tokens are signed with. Rotating keys means adding a
+// new key, making it active, and removing the old one only after the
+// tokens it signed have expired.
+type KeyRing struct {
+	mu        sync.RWMutex
+	dir       string
+	activeKID string
+	keys      map[string]SigningKey
+}
+
+// LoadKeyRing reads every <kid>.pem private key in dir. activeKID selects
+// the signing key; when empty the greatest kid in lexical order is used,
+// so date-based kids such as "2024-06-01" rotate naturally.
+func LoadKeyRing(dir, activeKID string) (*KeyRing, error) {
+	kr := &KeyRing{dir: dir}
+	err := kr.load(activeKID)
+	if err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Reload re-reads the key directory, e.g. after a new key was added.
+func (kr *KeyRing) Reload(activeKID string) error {
+	return kr.load(activeKID)
+}
+
+func (kr *KeyRing) load(activeKID string) error {
+	entries, err := os.ReadDir(kr.dir)
+	if err != nil {
+		return fmt.Errorf("couldn't read key directory: %w", err)
+	}
+
+	keys := map[string]SigningKey{}
+	kids := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), keyFileExt) {
+			continue
+		}
+		kid := strings.TrimSuffix(entry.Name(), keyFileExt)
+		dat, err := os.ReadFile(filepath.Join(kr.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		key, err := parseSigningKey(kid, dat)
+		if err != nil {
+			return fmt.Errorf("couldn't parse key %s: %w", entry.Name(), err)
+		}
+		keys[kid] = key
+		kids = append(kids, kid)
+	}
+	if len(kids) == 0 {
+		return fmt.Errorf("no %s keys found in %s", keyFileExt, kr.dir)
+	}
+
+	if activeKID == "" {
+		sort.Strings(kids)
+		activeKID = kids[len(kids)-1]
+	}
+	if _, ok := keys[activeKID]; !ok {
+		return fmt.Errorf("active key %q not found in %s", activeKID, kr.dir)
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys = keys
+	kr.activeKID = activeKID
+	return nil
+}
+
+func parseSigningKey(kid string, dat []byte) (SigningKey, error) {
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return SigningKey{}, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Signer: key}, nil
+	case ed25519.PrivateKey:
+		return SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Signer: key}, nil
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// GenerateKey writes a new Ed25519 private key to dir as <kid>.pem.
+func GenerateKey(dir, kid string) error {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, kid+keyFileExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// ActiveKey returns the key new tokens are signed with.
+func (kr *KeyRing) ActiveKey() SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[kr.activeKID]
+}
+
+// verificationKey is a jwt.Keyfunc that resolves the token's kid and makes
+// sure the token was signed with the algorithm that key belongs to.
+func (kr *KeyRing) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+
+	kr.mu.RLock()
+	key, ok := kr.keys[kid]
+	kr.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+	}
+	return key.Signer.Public(), nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key in the ring, so other services
+// can verify tokens signed by any key that hasn't been retired yet.
+func (kr *KeyRing) JWKS() JWKS {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	kids := make([]string, 0, len(kr.keys))
+	for kid := range kr.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		key := kr.keys[kid]
+		jwk := JWK{
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: key.Method.Alg(),
+		}
+		switch pub := key.Signer.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MakeStreamToken signs a token that lets userID play videoID. Media
+// elements can't send an Authorization header, so it travels in the
+// stream URL instead; keep expiresIn short.
+func MakeStreamToken(userID, videoID uuid.UUID, keys *KeyRing, expiresIn time.Duration) (string, error) {
+	return makeToken(TokenTypeStream, userID, videoID.String(), keys, expiresIn)
+}
+
+// ValidateStreamToken checks a stream token and returns the user and video
+// IDs it was issued for.
+func ValidateStreamToken(tokenString string, keys *KeyRing) (uuid.UUID, uuid.UUID, error) {
+	userID, videoIDString, err := parseToken(tokenString, TokenTypeStream, keys)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("invalid video ID")
+	}
+	return userID, videoID, nil
+}
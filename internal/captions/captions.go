@@ -0,0 +1,182 @@
+// Package captions converts caption files to WebVTT, the format browsers
+// and HLS players understand.
+package captions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalid is wrapped by errors about malformed caption files.
+var ErrInvalid = errors.New("invalid captions")
+
+// srtMarkup matches SRT markup WebVTT doesn't support: font tags and ASS
+// override blocks such as {\an8}.
+var srtMarkup = regexp.MustCompile(`(?i)</?font[^>]*>|\{\\[^}]*\}`)
+
+// ToWebVTT converts SubRip (SRT) or WebVTT captions to normalized WebVTT and
+// returns the time the last cue ends.
+func ToWebVTT(data []byte) ([]byte, time.Duration, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if !utf8.Valid(data) {
+		return nil, 0, fmt.Errorf("%w: not UTF-8 text", ErrInvalid)
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	if isWebVTT(text) {
+		end, err := lastCueEnd(text)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		return []byte(text), end, nil
+	}
+	return fromSRT(text)
+}
+
+func isWebVTT(text string) bool {
+	rest, ok := strings.CutPrefix(text, "WEBVTT")
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n')
+}
+
+// lastCueEnd checks every cue timing in a WebVTT file.
+func lastCueEnd(text string) (time.Duration, error) {
+	var last time.Duration
+	cues := 0
+	for i, line := range strings.Split(text, "\n") {
+		if !strings.Contains(line, "-->") {
+			continue
+		}
+		start, end, err := parseTiming(line)
+		if err != nil {
+			return 0, fmt.Errorf("%w: line %d: %v", ErrInvalid, i+1, err)
+		}
+		if end < start {
+			return 0, fmt.Errorf("%w: line %d: cue ends before it starts", ErrInvalid, i+1)
+		}
+		last = max(last, end)
+		cues++
+	}
+	if cues == 0 {
+		return 0, fmt.Errorf("%w: no cues", ErrInvalid)
+	}
+	return last, nil
+}
+
+func fromSRT(text string) ([]byte, time.Duration, error) {
+	var out strings.Builder
+	out.WriteString("WEBVTT\n")
+
+	// Blank lines separate cues, and some editors leave spaces on them.
+	trimmed := strings.Split(text, "\n")
+	for i, line := range trimmed {
+		trimmed[i] = strings.TrimRight(line, " \t")
+	}
+	text = strings.Join(trimmed, "\n")
+
+	var last time.Duration
+	cues := 0
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) == 1 && strings.TrimSpace(lines[0]) == "" {
+			continue
+		}
+		// The cue number is optional in practice.
+		if _, err := strconv.Atoi(strings.TrimSpace(lines[0])); err == nil {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 || !strings.Contains(lines[0], "-->") {
+			return nil, 0, fmt.Errorf("%w: cue %d has no timing line", ErrInvalid, cues+1)
+		}
+		start, end, err := parseTiming(lines[0])
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: cue %d: %v", ErrInvalid, cues+1, err)
+		}
+		if end < start {
+			return nil, 0, fmt.Errorf("%w: cue %d ends before it starts", ErrInvalid, cues+1)
+		}
+
+		fmt.Fprintf(&out, "\n%s --> %s\n", FormatTimestamp(start), FormatTimestamp(end))
+		for _, line := range lines[1:] {
+			line = srtMarkup.ReplaceAllString(line, "")
+			// "-->" ends a cue's text in WebVTT.
+			line = strings.ReplaceAll(line, "-->", "->")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			out.WriteString(line)
+			out.WriteString("\n")
+		}
+		last = max(last, end)
+		cues++
+	}
+	if cues == 0 {
+		return nil, 0, fmt.Errorf("%w: no cues", ErrInvalid)
+	}
+	return []byte(out.String()), last, nil
+}
+
+// parseTiming parses a cue timing line such as
+// "00:00:01,000 --> 00:00:04,500", ignoring WebVTT cue settings after it.
+func parseTiming(line string) (time.Duration, time.Duration, error) {
+	startString, rest, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return 0, 0, errors.New("missing end time")
+	}
+	start, err := parseTimestamp(strings.TrimSpace(startString))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTimestamp(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// parseTimestamp parses [hh:]mm:ss.ttt, also accepting SRT's comma.
+func parseTimestamp(s string) (time.Duration, error) {
+	clock, millisString, ok := strings.Cut(strings.Replace(s, ",", ".", 1), ".")
+	if !ok || len(millisString) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	parts := strings.Split(clock, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	if len(parts) == 2 {
+		parts = append([]string{"0"}, parts...)
+	}
+
+	var values [4]int
+	for i, part := range append(parts, millisString) {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		values[i] = v
+	}
+	if values[1] > 59 || values[2] > 59 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Duration(values[0])*time.Hour +
+		time.Duration(values[1])*time.Minute +
+		time.Duration(values[2])*time.Second +
+		time.Duration(values[3])*time.Millisecond, nil
+}
+
+// FormatTimestamp formats a cue time as a WebVTT timestamp.
+func FormatTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, ms%1000)
+}
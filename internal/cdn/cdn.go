@@ -0,0 +1,109 @@
+// Package cdn removes stale copies of replaced or deleted assets from
+// whichever CDN sits in front of storage.
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/edge"
+)
+
+// Invalidator asks a CDN to drop its cached copies of paths. Paths start
+// with "/" and may end in "*" to match a prefix. reference identifies the
+// request so that retrying it is idempotent where the CDN supports that.
+// It returns the CDN's ID for the request, if the CDN has one.
+type Invalidator interface {
+	Invalidate(ctx context.Context, reference string, paths []string) (string, error)
+}
+
+// Noop is used when nothing caches assets in front of storage.
+type Noop struct{}
+
+func (Noop) Invalidate(ctx context.Context, reference string, paths []string) (string, error) {
+	return "", nil
+}
+
+// Edge purges the built-in edge cache.
+type Edge struct {
+	Cache *edge.Cache
+}
+
+func (e Edge) Invalidate(ctx context.Context, reference string, paths []string) (string, error) {
+	purged := e.Cache.Purge(paths)
+	return fmt.Sprintf("purged %d", purged), nil
+}
+
+// CloudFront limits a single invalidation batch to this many paths, of
+// which only a few may use wildcards.
+const (
+	cloudFrontMaxPaths     = 3000
+	cloudFrontMaxWildcards = 15
+)
+
+// CloudFront creates invalidations on a CloudFront distribution, splitting
+// paths into as few batches as the service limits allow.
+type CloudFront struct {
+	Client         *cloudfront.Client
+	DistributionID string
+}
+
+func (c CloudFront) Invalidate(ctx context.Context, reference string, paths []string) (string, error) {
+	if len(paths) == 0 {
+		return "", errors.New("no paths to invalidate")
+	}
+
+	ids := []string{}
+	for i, batch := range cloudFrontBatches(paths) {
+		// CloudFront treats a repeated caller reference with the same
+		// paths as the same invalidation, so retries don't pile up.
+		callerReference := reference
+		if i > 0 {
+			callerReference = fmt.Sprintf("%s-%d", reference, i)
+		}
+		out, err := c.Client.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+			DistributionId: aws.String(c.DistributionID),
+			InvalidationBatch: &types.InvalidationBatch{
+				CallerReference: aws.String(callerReference),
+				Paths: &types.Paths{
+					Items:    batch,
+					Quantity: aws.Int32(int32(len(batch))),
+				},
+			},
+		})
+		if err != nil {
+			return strings.Join(ids, ","), err
+		}
+		if out.Invalidation != nil && out.Invalidation.Id != nil {
+			ids = append(ids, *out.Invalidation.Id)
+		}
+	}
+	return strings.Join(ids, ","), nil
+}
+
+func cloudFrontBatches(paths []string) [][]string {
+	batches := [][]string{}
+	batch := []string{}
+	wildcards := 0
+	for _, path := range paths {
+		wildcard := strings.HasSuffix(path, "*")
+		if len(batch) == cloudFrontMaxPaths || (wildcard && wildcards == cloudFrontMaxWildcards) {
+			batches = append(batches, batch)
+			batch = []string{}
+			wildcards = 0
+		}
+		batch = append(batch, path)
+		if wildcard {
+			wildcards++
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
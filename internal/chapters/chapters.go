@@ -0,0 +1,114 @@
+// Package chapters finds chapter markers in video descriptions and writes
+// them as a WebVTT chapters track.
+package chapters
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+)
+
+// MaxTitleLen is the longest chapter title allowed, in characters.
+const MaxTitleLen = 100
+
+// ErrInvalid is wrapped by errors about chapter lists that can't be used.
+var ErrInvalid = errors.New("invalid chapters")
+
+// Chapter is a titled section of a video that runs until the next one.
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// timestampLine matches description lines such as "1:02:03 Title",
+// "00:00 - Intro" or "[4:05] Outro".
+var timestampLine = regexp.MustCompile(`^\s*\[?((?:(\d{1,2}):)?(\d{1,2}):(\d{2}))\]?\s*(?:[-–—:|]\s*)?(\S.*?)\s*$`)
+
+// Parse finds chapters in a description, one "timestamp title" line each.
+// Like the big video sites it only takes them when the list starts at 0:00
+// and has at least two chapters in ascending order, so a stray timestamp
+// in the text isn't mistaken for one; otherwise it returns nil.
+func Parse(description string) []Chapter {
+	var chapters []Chapter
+	for _, line := range strings.Split(description, "\n") {
+		match := timestampLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		hours, _ := strconv.Atoi(match[2])
+		minutes, _ := strconv.Atoi(match[3])
+		seconds, _ := strconv.Atoi(match[4])
+		if seconds >= 60 || (match[2] != "" && minutes >= 60) {
+			continue
+		}
+		chapters = append(chapters, Chapter{
+			Start: time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second,
+			Title: match[5],
+		})
+	}
+	if Validate(chapters, 0) != nil {
+		return nil
+	}
+	return chapters
+}
+
+// Validate checks that chapters start at zero, are in ascending order and
+// have titles. A non-zero duration is the video's length, which every
+// chapter must start within.
+func Validate(chapters []Chapter, duration time.Duration) error {
+	if len(chapters) < 2 {
+		return fmt.Errorf("%w: a video needs at least two chapters", ErrInvalid)
+	}
+	for i, chapter := range chapters {
+		switch {
+		case i == 0 && chapter.Start != 0:
+			return fmt.Errorf("%w: the first chapter must start at 0:00", ErrInvalid)
+		case i > 0 && chapter.Start <= chapters[i-1].Start:
+			return fmt.Errorf("%w: chapter %d doesn't start after the one before it", ErrInvalid, i+1)
+		case duration > 0 && chapter.Start >= duration:
+			return fmt.Errorf("%w: chapter %d starts at %s, after the video ends at %s", ErrInvalid, i+1, FormatStart(chapter.Start), FormatStart(duration))
+		case strings.TrimSpace(chapter.Title) == "":
+			return fmt.Errorf("%w: chapter %d has no title", ErrInvalid, i+1)
+		case utf8.RuneCountInString(chapter.Title) > MaxTitleLen:
+			return fmt.Errorf("%w: chapter %d's title is longer than %d characters", ErrInvalid, i+1, MaxTitleLen)
+		}
+	}
+	return nil
+}
+
+// FormatStart formats a start time the way descriptions write it, e.g.
+// "4:05" or "1:02:03".
+func FormatStart(d time.Duration) string {
+	s := int64(d / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// ToWebVTT writes chapters as a WebVTT chapters track. Each cue ends where
+// the next chapter starts and the last one at duration; when the duration
+// isn't known the last cue is given a minute.
+func ToWebVTT(chapters []Chapter, duration time.Duration) []byte {
+	var out strings.Builder
+	out.WriteString("WEBVTT\n")
+	for i, chapter := range chapters {
+		end := chapter.Start + time.Minute
+		if i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		} else if duration > chapter.Start {
+			end = duration
+		}
+		// A line break would end the cue early and an arrow would read as a
+		// cue timing.
+		title := strings.ReplaceAll(strings.Join(strings.Fields(chapter.Title), " "), "-->", "->")
+		fmt.Fprintf(&out, "\n%d\n%s --> %s\n%s\n", i+1, captions.FormatTimestamp(chapter.Start), captions.FormatTimestamp(end), title)
+	}
+	return []byte(out.String())
+}
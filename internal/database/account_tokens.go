@@ -0,0 +1,111 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccountTokenPurpose names what a single-use account token may be used for.
+type AccountTokenPurpose string
+
+const (
+	AccountTokenEmailVerification AccountTokenPurpose = "email_verification"
+	AccountTokenPasswordReset     AccountTokenPurpose = "password_reset"
+	AccountTokenLoginChallenge    AccountTokenPurpose = "login_challenge"
+)
+
+type AccountToken struct {
+	ID        uuid.UUID           `json:"id"`
+	UserID    uuid.UUID           `json:"user_id"`
+	Purpose   AccountTokenPurpose `json:"purpose"`
+	CreatedAt time.Time           `json:"created_at"`
+	ExpiresAt time.Time           `json:"expires_at"`
+	UsedAt    *time.Time          `json:"used_at"`
+}
+
+// CreateAccountToken records a new token and invalidates any earlier unused
+// tokens the user holds for the same purpose, so only the latest emailed
+// link works.
+func (c Client) CreateAccountToken(userID uuid.UUID, purpose AccountTokenPurpose, expiresAt time.Time) (AccountToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return AccountToken{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE account_tokens
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`, userID.String(), purpose)
+	if err != nil {
+		return AccountToken{}, err
+	}
+
+	token := AccountToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Purpose:   purpose,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	}
+	_, err = tx.Exec(`
+	INSERT INTO account_tokens (
+		id,
+		user_id,
+		purpose,
+		created_at,
+		expires_at
+	) VALUES (?, ?, ?, ?, ?)
+	`, token.ID.String(), userID.String(), purpose, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return AccountToken{}, err
+	}
+
+	return token, tx.Commit()
+}
+
+// AccountTokenUsable reports whether the token exists, is unused and hasn't
+// expired, without consuming it.
+func (c Client) AccountTokenUsable(id, userID uuid.UUID, purpose AccountTokenPurpose) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM account_tokens
+		WHERE id = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+	)
+	`
+	var usable bool
+	err := c.db.QueryRow(query, id.String(), userID.String(), purpose, time.Now().UTC()).Scan(&usable)
+	return usable, err
+}
+
+// UseAccountToken marks the token as used and reports whether it was still
+// valid. Only the first caller for a given token gets true.
+func (c Client) UseAccountToken(id, userID uuid.UUID, purpose AccountTokenPurpose) (bool, error) {
+	query := `
+	UPDATE account_tokens
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+	`
+	result, err := c.db.Exec(query, id.String(), userID.String(), purpose, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// DeleteStaleAccountTokens removes tokens that expired before cutoff and
+// returns how many were deleted.
+func (c Client) DeleteStaleAccountTokens(cutoff time.Time) (int64, error) {
+	result, err := c.db.Exec("DELETE FROM account_tokens WHERE expires_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Blob is a stored file identified by the SHA-256 of its contents and the
+// storage holding it. Videos reference blobs for their thumbnail and video
+// file; identical uploads to the same storage share one blob, but a file
+// stored publicly and privately is two blobs, so neither copy stands in
+// for the other.
+type Blob struct {
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Storage     string    `json:"storage"`
+	Key         string    `json:"key"`
+	RefCount    int       `json:"ref_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Stored is set once the contents have been written to storage.
+	// Until then, whoever acquires the blob must write them.
+	Stored bool `json:"stored"`
+}
+
+type AcquireBlobParams struct {
+	SHA256      string
+	Size        int64
+	ContentType string
+	Storage     string
+	Key         string
+}
+
+// AcquireBlob adds a reference to the blob with the given hash in the
+// given storage, recording it first if it's new. It reports whether the
+// blob was created. Unless the returned blob is Stored, the caller must
+// write its contents to storage and then call MarkBlobStored. An existing
+// blob keeps its key, which callers must use rather than the one they
+// asked for.
+func (c Client) AcquireBlob(params AcquireBlobParams) (Blob, bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Blob{}, false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	result, err := tx.Exec(`
+	UPDATE blobs
+	SET ref_count = ref_count + 1, updated_at = ?
+	WHERE sha256 = ? AND storage = ?
+	`, now, params.SHA256, params.Storage)
+	if err != nil {
+		return Blob{}, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return Blob{}, false, err
+	}
+
+	created := n == 0
+	if created {
+		_, err = tx.Exec(`
+		INSERT INTO blobs (
+			sha256,
+			size,
+			content_type,
+			storage,
+			key,
+			ref_count,
+			created_at,
+			updated_at,
+			stored
+		) VALUES (?, ?, ?, ?, ?, 1, ?, ?, FALSE)
+		`, params.SHA256, params.Size, params.ContentType, params.Storage, params.Key, now, now)
+		if err != nil {
+			return Blob{}, false, err
+		}
+	}
+
+	blob, err := getBlob(tx, params.Storage, params.SHA256)
+	if err != nil {
+		return Blob{}, false, err
+	}
+	return blob, created, tx.Commit()
+}
+
+// MarkBlobStored records that a blob's contents have been written to
+// storage.
+func (c Client) MarkBlobStored(storage, sha256 string) error {
+	query := `
+	UPDATE blobs
+	SET stored = TRUE, updated_at = ?
+	WHERE sha256 = ? AND storage = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), sha256, storage)
+	return err
+}
+
+// ReleaseBlob drops a reference to a blob. Blobs left without references
+// are deleted later by garbage collection.
+func (c Client) ReleaseBlob(storage, sha256 string) error {
+	query := `
+	UPDATE blobs
+	SET ref_count = ref_count - 1, updated_at = ?
+	WHERE sha256 = ? AND storage = ? AND ref_count > 0
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), sha256, storage)
+	return err
+}
+
+// GetUnreferencedBlobs returns blobs that have had no references since
+// before the given time.
+func (c Client) GetUnreferencedBlobs(before time.Time, limit int) ([]Blob, error) {
+	query := `
+	SELECT sha256, size, content_type, storage, key, ref_count, created_at, updated_at, stored
+	FROM blobs
+	WHERE ref_count = 0 AND updated_at < ?
+	ORDER BY updated_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, before.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []Blob{}
+	for rows.Next() {
+		var blob Blob
+		if err := rows.Scan(
+			&blob.SHA256,
+			&blob.Size,
+			&blob.ContentType,
+			&blob.Storage,
+			&blob.Key,
+			&blob.RefCount,
+			&blob.CreatedAt,
+			&blob.UpdatedAt,
+			&blob.Stored,
+		); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// DeleteBlobIfUnreferenced forgets a blob unless it was referenced again
+// in the meantime, and reports whether it did. Its contents must only be
+// removed from storage when it did.
+func (c Client) DeleteBlobIfUnreferenced(storage, sha256 string) (bool, error) {
+	result, err := c.db.Exec("DELETE FROM blobs WHERE sha256 = ? AND storage = ? AND ref_count = 0", sha256, storage)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetBlob returns the blob with the given hash in the given storage, or a
+// zero Blob if there is none.
+func (c Client) GetBlob(storage, sha256 string) (Blob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Blob{}, err
+	}
+	defer tx.Rollback()
+
+	blob, err := getBlob(tx, storage, sha256)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Blob{}, nil
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	return blob, tx.Commit()
+}
+
+func getBlob(tx *sql.Tx, storage, sha256 string) (Blob, error) {
+	query := `
+	SELECT sha256, size, content_type, storage, key, ref_count, created_at, updated_at, stored
+	FROM blobs
+	WHERE sha256 = ? AND storage = ?
+	`
+	var blob Blob
+	err := tx.QueryRow(query, sha256, storage).Scan(
+		&blob.SHA256,
+		&blob.Size,
+		&blob.ContentType,
+		&blob.Storage,
+		&blob.Key,
+		&blob.RefCount,
+		&blob.CreatedAt,
+		&blob.UpdatedAt,
+		&blob.Stored,
+	)
+	return blob, err
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoCaption is a WebVTT caption track for a video, one per language.
+type VideoCaption struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	Language string    `json:"language"`
+	Label    string    `json:"label"`
+	URL      string    `json:"url"`
+	SHA256   string    `json:"sha256"`
+	// Storage is where the track's blob is kept.
+	Storage string `json:"-"`
+	// DurationMS is when the last cue ends, which HLS subtitle playlists
+	// need.
+	DurationMS int64 `json:"duration_ms"`
+	// AutoGenerated marks tracks made by speech recognition rather than
+	// uploaded.
+	AutoGenerated bool      `json:"auto_generated"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type UpsertVideoCaptionParams struct {
+	VideoID       uuid.UUID
+	Language      string
+	Label         string
+	URL           string
+	SHA256        string
+	Storage       string
+	DurationMS    int64
+	AutoGenerated bool
+}
+
+// UpsertVideoCaption stores the caption track for a language, replacing
+// any track the video already has for it.
+func (c Client) UpsertVideoCaption(params UpsertVideoCaptionParams) (VideoCaption, error) {
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO video_captions (
+		video_id,
+		language,
+		label,
+		url,
+		sha256,
+		storage,
+		duration_ms,
+		auto_generated,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id, language) DO UPDATE SET
+		label = excluded.label,
+		url = excluded.url,
+		sha256 = excluded.sha256,
+		storage = excluded.storage,
+		duration_ms = excluded.duration_ms,
+		auto_generated = excluded.auto_generated,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, params.VideoID.String(), params.Language, params.Label, params.URL, params.SHA256, params.Storage, params.DurationMS, params.AutoGenerated, now, now)
+	if err != nil {
+		return VideoCaption{}, err
+	}
+	return c.GetVideoCaption(params.VideoID, params.Language)
+}
+
+// GetVideoCaption returns a video's caption track for a language, or a zero
+// VideoCaption if it has none.
+func (c Client) GetVideoCaption(videoID uuid.UUID, language string) (VideoCaption, error) {
+	query := `
+	SELECT video_id, language, label, url, sha256, storage, duration_ms, auto_generated, created_at, updated_at
+	FROM video_captions
+	WHERE video_id = ? AND language = ?
+	`
+	var caption VideoCaption
+	err := c.db.QueryRow(query, videoID.String(), language).Scan(
+		&caption.VideoID,
+		&caption.Language,
+		&caption.Label,
+		&caption.URL,
+		&caption.SHA256,
+		&caption.Storage,
+		&caption.DurationMS,
+		&caption.AutoGenerated,
+		&caption.CreatedAt,
+		&caption.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoCaption{}, nil
+	}
+	if err != nil {
+		return VideoCaption{}, err
+	}
+	return caption, nil
+}
+
+func (c Client) GetVideoCaptions(videoID uuid.UUID) ([]VideoCaption, error) {
+	query := `
+	SELECT video_id, language, label, url, sha256, storage, duration_ms, auto_generated, created_at, updated_at
+	FROM video_captions
+	WHERE video_id = ?
+	ORDER BY language
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	captions := []VideoCaption{}
+	for rows.Next() {
+		var caption VideoCaption
+		if err := rows.Scan(
+			&caption.VideoID,
+			&caption.Language,
+			&caption.Label,
+			&caption.URL,
+			&caption.SHA256,
+			&caption.Storage,
+			&caption.DurationMS,
+			&caption.AutoGenerated,
+			&caption.CreatedAt,
+			&caption.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		captions = append(captions, caption)
+	}
+	return captions, rows.Err()
+}
+
+func (c Client) DeleteVideoCaption(videoID uuid.UUID, language string) error {
+	query := `
+	DELETE FROM video_captions
+	WHERE video_id = ? AND language = ?
+	`
+	_, err := c.db.Exec(query, videoID.String(), language)
+	return err
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CDNInvalidationStatus tracks an invalidation from being requested to
+// being accepted by the CDN.
+type CDNInvalidationStatus string
+
+const (
+	CDNInvalidationPending   CDNInvalidationStatus = "pending"
+	CDNInvalidationSubmitted CDNInvalidationStatus = "submitted"
+	CDNInvalidationFailed    CDNInvalidationStatus = "failed"
+)
+
+type CDNInvalidation struct {
+	ID            uuid.UUID             `json:"id"`
+	CreatedAt     time.Time             `json:"created_at"`
+	UpdatedAt     time.Time             `json:"updated_at"`
+	Reason        string                `json:"reason"`
+	Paths         []string              `json:"paths"`
+	Status        CDNInvalidationStatus `json:"status"`
+	Attempts      int                   `json:"attempts"`
+	NextAttemptAt time.Time             `json:"next_attempt_at"`
+	LastError     string                `json:"last_error"`
+	ProviderRef   string                `json:"provider_ref"`
+}
+
+// CreateCDNInvalidation records paths to invalidate as pending, due now.
+func (c Client) CreateCDNInvalidation(reason string, paths []string) (CDNInvalidation, error) {
+	now := time.Now().UTC()
+	invalidation := CDNInvalidation{
+		ID:            uuid.New(),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Reason:        reason,
+		Paths:         paths,
+		Status:        CDNInvalidationPending,
+		NextAttemptAt: now,
+	}
+	query := `
+	INSERT INTO cdn_invalidations (
+		id,
+		created_at,
+		updated_at,
+		reason,
+		paths,
+		status,
+		next_attempt_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		invalidation.ID.String(),
+		now,
+		now,
+		reason,
+		strings.Join(paths, "\n"),
+		invalidation.Status,
+		now,
+	)
+	if err != nil {
+		return CDNInvalidation{}, err
+	}
+	return invalidation, nil
+}
+
+// GetDueCDNInvalidations returns the oldest pending invalidations whose
+// next attempt is due.
+func (c Client) GetDueCDNInvalidations(now time.Time, limit int) ([]CDNInvalidation, error) {
+	query := `
+	SELECT id, created_at, updated_at, reason, paths, status, attempts, next_attempt_at, last_error, provider_ref
+	FROM cdn_invalidations
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY created_at
+	LIMIT ?
+	`
+	return c.queryCDNInvalidations(query, CDNInvalidationPending, now.UTC(), limit)
+}
+
+func (c Client) GetCDNInvalidations(limit int) ([]CDNInvalidation, error) {
+	query := `
+	SELECT id, created_at, updated_at, reason, paths, status, attempts, next_attempt_at, last_error, provider_ref
+	FROM cdn_invalidations
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	return c.queryCDNInvalidations(query, limit)
+}
+
+func (c Client) queryCDNInvalidations(query string, args ...any) ([]CDNInvalidation, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invalidations := []CDNInvalidation{}
+	for rows.Next() {
+		var invalidation CDNInvalidation
+		var paths string
+		if err := rows.Scan(
+			&invalidation.ID,
+			&invalidation.CreatedAt,
+			&invalidation.UpdatedAt,
+			&invalidation.Reason,
+			&paths,
+			&invalidation.Status,
+			&invalidation.Attempts,
+			&invalidation.NextAttemptAt,
+			&invalidation.LastError,
+			&invalidation.ProviderRef,
+		); err != nil {
+			return nil, err
+		}
+		invalidation.Paths = strings.Split(paths, "\n")
+		invalidations = append(invalidations, invalidation)
+	}
+	return invalidations, rows.Err()
+}
+
+// MarkCDNInvalidationsSubmitted records that the CDN accepted the
+// invalidations under providerRef.
+func (c Client) MarkCDNInvalidationsSubmitted(ids []uuid.UUID, providerRef string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE cdn_invalidations
+	SET status = ?, attempts = attempts + 1, provider_ref = ?, last_error = '', updated_at = ?
+	WHERE id = ?
+	`
+	now := time.Now().UTC()
+	for _, id := range ids {
+		_, err = tx.Exec(query, CDNInvalidationSubmitted, providerRef, now, id.String())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RecordCDNInvalidationFailure counts a failed attempt. The invalidation
+// stays pending until nextAttemptAt, or is marked failed when it is nil.
+func (c Client) RecordCDNInvalidationFailure(id uuid.UUID, lastError string, nextAttemptAt *time.Time) error {
+	status := CDNInvalidationFailed
+	now := time.Now().UTC()
+	next := now
+	if nextAttemptAt != nil {
+		status = CDNInvalidationPending
+		next = nextAttemptAt.UTC()
+	}
+	query := `
+	UPDATE cdn_invalidations
+	SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, lastError, next, now, id.String())
+	return err
+}
+
+// RetryCDNInvalidation makes a failed invalidation pending again with a
+// fresh attempt budget. It reports false if id isn't a failed invalidation.
+func (c Client) RetryCDNInvalidation(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE cdn_invalidations
+	SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+	WHERE id = ? AND status = ?
+	`
+	now := time.Now().UTC()
+	result, err := c.db.Exec(query, CDNInvalidationPending, now, now, id.String(), CDNInvalidationFailed)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c Client) GetCDNInvalidation(id uuid.UUID) (CDNInvalidation, error) {
+	query := `
+	SELECT id, created_at, updated_at, reason, paths, status, attempts, next_attempt_at, last_error, provider_ref
+	FROM cdn_invalidations
+	WHERE id = ?
+	`
+	invalidations, err := c.queryCDNInvalidations(query, id.String())
+	if err != nil {
+		return CDNInvalidation{}, err
+	}
+	if len(invalidations) == 0 {
+		return CDNInvalidation{}, nil
+	}
+	return invalidations[0], nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// VideoChapter is a titled section of a video, running until the next
+// chapter starts.
+type VideoChapter struct {
+	StartMS int64  `json:"start_ms"`
+	Title   string `json:"title"`
+}
+
+func (c Client) GetVideoChapters(videoID uuid.UUID) ([]VideoChapter, error) {
+	query := `
+	SELECT start_ms, title
+	FROM video_chapters
+	WHERE video_id = ?
+	ORDER BY start_ms
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []VideoChapter{}
+	for rows.Next() {
+		var chapter VideoChapter
+		if err := rows.Scan(&chapter.StartMS, &chapter.Title); err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, rows.Err()
+}
+
+// SetVideoChapters replaces a video's chapters. manual records whether
+// they were set explicitly, in which case they no longer follow the
+// description.
+func (c Client) SetVideoChapters(videoID uuid.UUID, chapters []VideoChapter, manual bool) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_chapters WHERE video_id = ?", videoID.String())
+	if err != nil {
+		return err
+	}
+	for _, chapter := range chapters {
+		_, err = tx.Exec(`
+		INSERT INTO video_chapters (video_id, start_ms, title)
+		VALUES (?, ?, ?)
+		`, videoID.String(), chapter.StartMS, chapter.Title)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE videos SET chapters_manual = ? WHERE id = ?", manual, videoID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
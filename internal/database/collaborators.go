@@ -0,0 +1,87 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VideoCollaborator struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c Client) AddVideoCollaborator(videoID, userID uuid.UUID) error {
+	query := `
+	INSERT OR IGNORE INTO video_collaborators (
+		video_id,
+		user_id,
+		created_at
+	) VALUES (?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, videoID.String(), userID.String())
+	return err
+}
+
+func (c Client) RemoveVideoCollaborator(videoID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM video_collaborators
+	WHERE video_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, videoID.String(), userID.String())
+	return err
+}
+
+func (c Client) IsVideoCollaborator(videoID, userID uuid.UUID) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM video_collaborators
+		WHERE video_id = ? AND user_id = ?
+	)
+	`
+	var exists bool
+	err := c.db.QueryRow(query, videoID.String(), userID.String()).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (c Client) GetVideoCollaborators(videoID uuid.UUID) ([]VideoCollaborator, error) {
+	query := `
+	SELECT
+		vc.video_id,
+		vc.user_id,
+		u.email,
+		vc.created_at
+	FROM video_collaborators vc
+	JOIN users u ON u.id = vc.user_id
+	WHERE vc.video_id = ?
+	ORDER BY vc.created_at
+	`
+
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []VideoCollaborator{}
+	for rows.Next() {
+		var collaborator VideoCollaborator
+		if err := rows.Scan(
+			&collaborator.VideoID,
+			&collaborator.UserID,
+			&collaborator.Email,
+			&collaborator.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+
+	return collaborators, rows.Err()
+}
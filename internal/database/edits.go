@@ -0,0 +1,63 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// VideoEditSegment is a stretch of the unedited video kept by an edit.
+// An edit plays its segments back to back, in order.
+type VideoEditSegment struct {
+	StartMS int64 `json:"start_ms"`
+	EndMS   int64 `json:"end_ms"`
+}
+
+// GetVideoEditSegments returns the segments a video's published rendition
+// was cut from, in order, or none if it hasn't been edited.
+func (c Client) GetVideoEditSegments(videoID uuid.UUID) ([]VideoEditSegment, error) {
+	query := `
+	SELECT start_ms, end_ms
+	FROM video_edit_segments
+	WHERE video_id = ?
+	ORDER BY position
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	segments := []VideoEditSegment{}
+	for rows.Next() {
+		var segment VideoEditSegment
+		if err := rows.Scan(&segment.StartMS, &segment.EndMS); err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, rows.Err()
+}
+
+// SetVideoEditSegments replaces the segments recorded for a video's edit.
+// An empty list records that the video is unedited.
+func (c Client) SetVideoEditSegments(videoID uuid.UUID, segments []VideoEditSegment) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_edit_segments WHERE video_id = ?", videoID.String())
+	if err != nil {
+		return err
+	}
+	for i, segment := range segments {
+		_, err = tx.Exec(`
+		INSERT INTO video_edit_segments (video_id, position, start_ms, end_ms)
+		VALUES (?, ?, ?, ?)
+		`, videoID.String(), i, segment.StartMS, segment.EndMS)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
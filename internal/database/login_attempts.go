@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginAttemptResult is the outcome recorded for a login attempt.
+type LoginAttemptResult string
+
+const (
+	LoginSucceeded      LoginAttemptResult = "succeeded"
+	LoginUnknownEmail   LoginAttemptResult = "unknown_email"
+	LoginBadPassword    LoginAttemptResult = "bad_password"
+	LoginRejectedLocked LoginAttemptResult = "locked"
+	LoginTOTPRequired   LoginAttemptResult = "totp_required"
+	LoginBadTOTP        LoginAttemptResult = "bad_totp"
+)
+
+type LoginAttempt struct {
+	ID        uuid.UUID          `json:"id"`
+	Email     string             `json:"email"`
+	IP        string             `json:"ip"`
+	UserID    *uuid.UUID         `json:"user_id"`
+	Result    LoginAttemptResult `json:"result"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+type CreateLoginAttemptParams struct {
+	Email  string
+	IP     string
+	UserID *uuid.UUID
+	Result LoginAttemptResult
+}
+
+// LoginFailures summarizes recent failed attempts.
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+func (c Client) CreateLoginAttempt(params CreateLoginAttemptParams) error {
+	query := `
+	INSERT INTO login_attempts (
+		id,
+		email,
+		ip,
+		user_id,
+		result,
+		created_at
+	) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	var userID *string
+	if params.UserID != nil {
+		s := params.UserID.String()
+		userID = &s
+	}
+	_, err := c.db.Exec(query, uuid.New().String(), params.Email, params.IP, userID, params.Result, time.Now().UTC())
+	return err
+}
+
+// GetLoginFailuresByEmail counts failed attempts for email made after both
+// since and its most recent successful login.
+func (c Client) GetLoginFailuresByEmail(email string, since time.Time) (LoginFailures, error) {
+	return c.getLoginFailures("email", email, since, true)
+}
+
+// GetLoginFailuresByIP counts failed attempts from ip made after since.
+// Successful logins don't reset it, or signing in to an account of one's
+// own between guesses would let an IP keep guessing at others.
+func (c Client) GetLoginFailuresByIP(ip string, since time.Time) (LoginFailures, error) {
+	return c.getLoginFailures("ip", ip, since, false)
+}
+
+func (c Client) getLoginFailures(column, value string, since time.Time, resetOnSuccess bool) (LoginFailures, error) {
+	// column is never user input, it's one of the two callers above.
+	query := `
+	SELECT COUNT(*), MAX(created_at)
+	FROM login_attempts
+	WHERE ` + column + ` = ?
+		AND result IN (?, ?, ?)
+		AND created_at > ?
+	`
+	args := []any{value, LoginUnknownEmail, LoginBadPassword, LoginBadTOTP, since.UTC()}
+	if resetOnSuccess {
+		query += `
+		AND created_at > COALESCE((
+			SELECT MAX(created_at)
+			FROM login_attempts
+			WHERE ` + column + ` = ? AND result = ?
+		), '')
+	`
+		args = append(args, value, LoginSucceeded)
+	}
+	var failures LoginFailures
+	var last sql.NullString
+	err := c.db.QueryRow(query, args...).Scan(&failures.Count, &last)
+	if err != nil {
+		return LoginFailures{}, err
+	}
+	if last.Valid {
+		failures.Last, err = parseSQLiteTime(last.String)
+		if err != nil {
+			return LoginFailures{}, err
+		}
+	}
+	return failures, nil
+}
+
+func (c Client) GetLoginAttempts(email string, limit int) ([]LoginAttempt, error) {
+	query := `
+	SELECT id, email, ip, user_id, result, created_at
+	FROM login_attempts
+	WHERE email = ?
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, email, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var attempt LoginAttempt
+		if err := rows.Scan(
+			&attempt.ID,
+			&attempt.Email,
+			&attempt.IP,
+			&attempt.UserID,
+			&attempt.Result,
+			&attempt.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
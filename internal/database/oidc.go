@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCAuthRequest is the state kept between redirecting a browser to the
+// identity provider and handling its callback.
+type OIDCAuthRequest struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+func (c Client) CreateOIDCAuthRequest(req OIDCAuthRequest) error {
+	query := `
+	INSERT INTO oidc_auth_requests (
+		state,
+		nonce,
+		code_verifier,
+		created_at,
+		expires_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err := c.db.Exec(query, req.State, req.Nonce, req.CodeVerifier, req.ExpiresAt.UTC())
+	return err
+}
+
+// ConsumeOIDCAuthRequest deletes and returns the request for state, or nil
+// if there is none or it has expired. Expired requests are purged too.
+func (c Client) ConsumeOIDCAuthRequest(state string) (*OIDCAuthRequest, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var req OIDCAuthRequest
+	err = tx.QueryRow(`
+	SELECT state, nonce, code_verifier, expires_at
+	FROM oidc_auth_requests
+	WHERE state = ?
+	`, state).Scan(&req.State, &req.Nonce, &req.CodeVerifier, &req.ExpiresAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	found := err == nil
+
+	_, err = tx.Exec("DELETE FROM oidc_auth_requests WHERE state = ? OR expires_at < ?", state, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if !found || req.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+	return &req, nil
+}
+
+// GetUserIDByIdentity returns the user linked to an external identity, or
+// uuid.Nil if it hasn't been linked yet.
+func (c Client) GetUserIDByIdentity(issuer, subject string) (uuid.UUID, error) {
+	query := `
+	SELECT user_id
+	FROM user_identities
+	WHERE issuer = ? AND subject = ?
+	`
+	var userID uuid.UUID
+	err := c.db.QueryRow(query, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+func (c Client) LinkUserIdentity(issuer, subject string, userID uuid.UUID) error {
+	query := `
+	INSERT INTO user_identities (
+		issuer,
+		subject,
+		user_id,
+		created_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, issuer, subject, userID.String())
+	return err
+}
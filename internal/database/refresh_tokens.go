@@ -9,28 +9,46 @@ import (
 
 type RefreshToken struct {
 	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+}
+
+// Session is the client-facing view of a refresh token. It never includes
+// the token itself.
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
+			id,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			user_agent,
+			ip
+		) VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.db.Exec(query, params.Token, uuid.New().String(), params.UserID.String(), params.ExpiresAt, params.UserAgent, params.IP)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -48,16 +66,72 @@ func (c Client) RevokeRefreshToken(token string) error {
 	return err
 }
 
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
+
+// RevokeSession revokes one of the user's sessions and reports whether it
+// existed and was still active.
+func (c Client) RevokeSession(userID, sessionID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	result, err := c.db.Exec(query, sessionID.String(), userID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// IsSessionActive reports whether the user's session exists and is
+// neither revoked nor expired.
+func (c Client) IsSessionActive(userID, sessionID uuid.UUID) (bool, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM refresh_tokens
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	`
+	var n int
+	err := c.db.QueryRow(query, sessionID.String(), userID.String(), time.Now().UTC()).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// TouchRefreshToken records that the token was just used to refresh.
+func (c Client) TouchRefreshToken(token, ip string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET last_used_at = ?, ip = ?
+		WHERE token = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), ip, token)
+	return err
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, id, created_at, updated_at, user_id, expires_at, revoked_at, last_used_at, user_agent, ip
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
 	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+		Scan(&rt.Token, &rt.ID, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.LastUsedAt, &rt.UserAgent, &rt.IP)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -73,6 +147,54 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	return rt, nil
 }
 
+// GetActiveSessions lists the user's sessions that are neither revoked nor
+// expired, most recently used first.
+func (c Client) GetActiveSessions(userID uuid.UUID) ([]Session, error) {
+	query := `
+		SELECT id, created_at, last_used_at, expires_at, user_agent, ip
+		FROM refresh_tokens
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY COALESCE(last_used_at, created_at) DESC
+	`
+	rows, err := c.db.Query(query, userID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+			&session.UserAgent,
+			&session.IP,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteStaleRefreshTokens removes tokens that expired or were revoked
+// before cutoff and returns how many were deleted.
+func (c Client) DeleteStaleRefreshTokens(cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE expires_at < ? OR revoked_at < ?
+	`
+	cutoff = cutoff.UTC()
+	result, err := c.db.Exec(query, cutoff, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (c Client) DeleteRefreshToken(token string) error {
 	query := `
 		DELETE FROM refresh_tokens
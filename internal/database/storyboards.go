@@ -0,0 +1,56 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// GetVideoStoryboardSheets returns the SHA-256s of the sprite sheets in a
+// video's storyboard, in order.
+func (c Client) GetVideoStoryboardSheets(videoID uuid.UUID) ([]string, error) {
+	query := `
+	SELECT sha256
+	FROM video_storyboard_sheets
+	WHERE video_id = ?
+	ORDER BY position
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sheets := []string{}
+	for rows.Next() {
+		var sha string
+		if err := rows.Scan(&sha); err != nil {
+			return nil, err
+		}
+		sheets = append(sheets, sha)
+	}
+	return sheets, rows.Err()
+}
+
+// SetVideoStoryboardSheets replaces the sprite sheets recorded for a
+// video's storyboard. The index is stored on the video itself.
+func (c Client) SetVideoStoryboardSheets(videoID uuid.UUID, sheetSHA256s []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_storyboard_sheets WHERE video_id = ?", videoID.String())
+	if err != nil {
+		return err
+	}
+	for i, sha := range sheetSHA256s {
+		_, err = tx.Exec(`
+		INSERT INTO video_storyboard_sheets (video_id, position, sha256)
+		VALUES (?, ?, ?)
+		`, videoID.String(), i, sha)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserTOTP struct {
+	UserID    uuid.UUID  `json:"user_id"`
+	Secret    string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	EnabledAt *time.Time `json:"enabled_at"`
+	// LastStep is the most recent TOTP time step accepted, used to reject
+	// replayed codes.
+	LastStep int64 `json:"-"`
+}
+
+func (c Client) GetUserTOTP(userID uuid.UUID) (*UserTOTP, error) {
+	query := `
+	SELECT user_id, secret, created_at, enabled_at, last_step
+	FROM user_totp
+	WHERE user_id = ?
+	`
+	var totp UserTOTP
+	err := c.db.QueryRow(query, userID.String()).
+		Scan(&totp.UserID, &totp.Secret, &totp.CreatedAt, &totp.EnabledAt, &totp.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// SetPendingTOTP stores a new secret that isn't enforced until EnableTOTP
+// is called, replacing any earlier unconfirmed enrollment.
+func (c Client) SetPendingTOTP(userID uuid.UUID, secret string) error {
+	query := `
+	INSERT INTO user_totp (user_id, secret, created_at, enabled_at, last_step)
+	VALUES (?, ?, CURRENT_TIMESTAMP, NULL, 0)
+	ON CONFLICT(user_id) DO UPDATE SET
+		secret = excluded.secret,
+		created_at = excluded.created_at,
+		last_step = 0
+	WHERE user_totp.enabled_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), secret)
+	return err
+}
+
+// EnableTOTP turns on two-factor auth for the user and replaces their
+// recovery codes with the given hashes.
+func (c Client) EnableTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE user_totp
+	SET enabled_at = CURRENT_TIMESTAMP, last_step = ?
+	WHERE user_id = ?
+	`, step, userID.String())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID.String())
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(`
+		INSERT INTO totp_recovery_codes (code_hash, user_id, created_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, hash, userID.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AdvanceTOTPStep records step as used. It returns false if an equal or
+// later step was already accepted, e.g. by a concurrent request.
+func (c Client) AdvanceTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	query := `
+	UPDATE user_totp
+	SET last_step = ?
+	WHERE user_id = ? AND last_step < ?
+	`
+	result, err := c.db.Exec(query, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode consumes the recovery code with the given hash and
+// reports whether it existed and was unused.
+func (c Client) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	query := `
+	UPDATE totp_recovery_codes
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := c.db.Exec(query, userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (c Client) DeleteUserTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TranscriptionJobStatus tracks automatic captioning of a video.
+type TranscriptionJobStatus string
+
+const (
+	TranscriptionPending TranscriptionJobStatus = "pending"
+	TranscriptionRunning TranscriptionJobStatus = "running"
+	TranscriptionDone    TranscriptionJobStatus = "done"
+	TranscriptionFailed  TranscriptionJobStatus = "failed"
+)
+
+type TranscriptionJob struct {
+	ID      uuid.UUID `json:"id"`
+	VideoID uuid.UUID `json:"video_id"`
+	// Language is the requested spoken language, or "auto".
+	Language      string                 `json:"language"`
+	Status        TranscriptionJobStatus `json:"status"`
+	Attempts      int                    `json:"attempts"`
+	NextAttemptAt time.Time              `json:"next_attempt_at"`
+	LastError     string                 `json:"last_error"`
+	// CaptionLanguage is the caption track the job produced, once done.
+	CaptionLanguage string    `json:"caption_language"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+const transcriptionJobColumns = `id, video_id, language, status, attempts, next_attempt_at, last_error, caption_language, created_at, updated_at`
+
+// CreateTranscriptionJob queues a video for transcription, due now. A job
+// that is already waiting for the video is returned instead of adding
+// another, since jobs always transcribe the video's current file.
+func (c Client) CreateTranscriptionJob(videoID uuid.UUID, language string) (TranscriptionJob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return TranscriptionJob{}, err
+	}
+	defer tx.Rollback()
+
+	jobs, err := queryTranscriptionJobs(tx, `
+	SELECT `+transcriptionJobColumns+`
+	FROM transcription_jobs
+	WHERE video_id = ? AND status = ? AND language = ?
+	`, videoID.String(), TranscriptionPending, language)
+	if err != nil {
+		return TranscriptionJob{}, err
+	}
+	if len(jobs) > 0 {
+		return jobs[0], tx.Commit()
+	}
+
+	now := time.Now().UTC()
+	job := TranscriptionJob{
+		ID:            uuid.New(),
+		VideoID:       videoID,
+		Language:      language,
+		Status:        TranscriptionPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	query := `
+	INSERT INTO transcription_jobs (
+		id,
+		video_id,
+		language,
+		status,
+		next_attempt_at,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, job.ID.String(), videoID.String(), language, job.Status, now, now, now)
+	if err != nil {
+		return TranscriptionJob{}, err
+	}
+	return job, tx.Commit()
+}
+
+func (c Client) GetTranscriptionJobs(videoID uuid.UUID) ([]TranscriptionJob, error) {
+	return queryTranscriptionJobs(c.db, `
+	SELECT `+transcriptionJobColumns+`
+	FROM transcription_jobs
+	WHERE video_id = ?
+	ORDER BY created_at DESC
+	`, videoID.String())
+}
+
+// ClaimTranscriptionJob marks the oldest due pending job as running and
+// returns it, or returns a zero TranscriptionJob when none is due.
+func (c Client) ClaimTranscriptionJob(now time.Time) (TranscriptionJob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return TranscriptionJob{}, err
+	}
+	defer tx.Rollback()
+
+	jobs, err := queryTranscriptionJobs(tx, `
+	SELECT `+transcriptionJobColumns+`
+	FROM transcription_jobs
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY created_at
+	LIMIT 1
+	`, TranscriptionPending, now.UTC())
+	if err != nil {
+		return TranscriptionJob{}, err
+	}
+	if len(jobs) == 0 {
+		return TranscriptionJob{}, nil
+	}
+
+	job := jobs[0]
+	job.Status = TranscriptionRunning
+	job.UpdatedAt = time.Now().UTC()
+	_, err = tx.Exec(`
+	UPDATE transcription_jobs
+	SET status = ?, updated_at = ?
+	WHERE id = ?
+	`, job.Status, job.UpdatedAt, job.ID.String())
+	if err != nil {
+		return TranscriptionJob{}, err
+	}
+	return job, tx.Commit()
+}
+
+// CompleteTranscriptionJob records that a job finished, producing the
+// caption track for captionLanguage, or none when it is empty.
+func (c Client) CompleteTranscriptionJob(id uuid.UUID, captionLanguage, note string) error {
+	query := `
+	UPDATE transcription_jobs
+	SET status = ?, attempts = attempts + 1, caption_language = ?, last_error = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, TranscriptionDone, captionLanguage, note, time.Now().UTC(), id.String())
+	return err
+}
+
+// RecordTranscriptionFailure counts a failed attempt. The job goes back to
+// pending until nextAttemptAt, or is marked failed when it is nil.
+func (c Client) RecordTranscriptionFailure(id uuid.UUID, lastError string, nextAttemptAt *time.Time) error {
+	status := TranscriptionFailed
+	now := time.Now().UTC()
+	next := now
+	if nextAttemptAt != nil {
+		status = TranscriptionPending
+		next = nextAttemptAt.UTC()
+	}
+	query := `
+	UPDATE transcription_jobs
+	SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, lastError, next, now, id.String())
+	return err
+}
+
+// RequeueRunningTranscriptionJobs puts jobs that were running when the
+// server stopped back in the queue.
+func (c Client) RequeueRunningTranscriptionJobs() error {
+	query := `
+	UPDATE transcription_jobs
+	SET status = ?, updated_at = ?
+	WHERE status = ?
+	`
+	_, err := c.db.Exec(query, TranscriptionPending, time.Now().UTC(), TranscriptionRunning)
+	return err
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryTranscriptionJobs(q queryer, query string, args ...any) ([]TranscriptionJob, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []TranscriptionJob{}
+	for rows.Next() {
+		var job TranscriptionJob
+		if err := rows.Scan(
+			&job.ID,
+			&job.VideoID,
+			&job.Language,
+			&job.Status,
+			&job.Attempts,
+			&job.NextAttemptAt,
+			&job.LastError,
+			&job.CaptionLanguage,
+			&job.CreatedAt,
+			&job.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
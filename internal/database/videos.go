@@ -14,6 +14,54 @@ type Video struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	// ThumbnailSHA256 and VideoSHA256 are the hex SHA-256 of the stored
+	// files, so clients can verify what they download.
+	ThumbnailSHA256 *string `json:"thumbnail_sha256"`
+	VideoSHA256     *string `json:"video_sha256"`
+	// SourceURL is the original upload, kept on request when it had to be
+	// converted to MP4.
+	SourceURL    *string `json:"source_url"`
+	SourceSHA256 *string `json:"source_sha256"`
+	// StoryboardURL is the WebVTT index of the sprite sheets players show
+	// as previews while scrubbing.
+	StoryboardURL    *string `json:"storyboard_url"`
+	StoryboardSHA256 *string `json:"storyboard_sha256"`
+	// PreviewURL is a short muted looping preview as animated WebP, for
+	// cards; PreviewMP4URL is the same clip as a much smaller MP4.
+	PreviewURL       *string `json:"preview_url"`
+	PreviewSHA256    *string `json:"preview_sha256"`
+	PreviewMP4URL    *string `json:"preview_mp4_url"`
+	PreviewMP4SHA256 *string `json:"preview_mp4_sha256"`
+	// AudioURL is the video's sound alone, for listening like a podcast.
+	AudioURL    *string `json:"audio_url"`
+	AudioSHA256 *string `json:"audio_sha256"`
+	// Watermarked is set when the published video was stamped with a
+	// watermark. OriginalSHA256 is then the untouched upload, which is
+	// kept for editors only and never handed out with the video.
+	Watermarked    bool    `json:"watermarked"`
+	OriginalSHA256 *string `json:"-"`
+	// Edited is set when the published video was cut from the upload with
+	// GetVideoEditSegments. UneditedSHA256 is the rendition before the
+	// cut, kept so edits can be redone from it or undone.
+	Edited         bool    `json:"edited"`
+	UneditedSHA256 *string `json:"-"`
+	// LoudnessLUFS is the upload's integrated loudness, measured to EBU
+	// R128, or nil if it has no audible sound. LoudnessNormalized is set
+	// when the published audio was brought to the deployment's target.
+	LoudnessLUFS       *float64 `json:"loudness_lufs"`
+	LoudnessNormalized bool     `json:"loudness_normalized"`
+	// DurationMS is the length of the video file, as probed on upload.
+	DurationMS *int64 `json:"duration_ms"`
+	// ChaptersManual is set when chapters were set through the API rather
+	// than parsed from the description, which then no longer updates them.
+	ChaptersManual bool `json:"chapters_manual"`
+	// Chapters and ChaptersURL, the WebVTT chapters track, are filled in
+	// by handlers like Captions.
+	Chapters    []VideoChapter `json:"chapters,omitempty"`
+	ChaptersURL *string        `json:"chapters_url,omitempty"`
+	// Captions aren't stored with the video; handlers fill them in with
+	// GetVideoCaptions.
+	Captions []VideoCaption `json:"captions,omitempty"`
 	CreateVideoParams
 }
 
@@ -21,6 +69,8 @@ type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// IsPrivate limits viewing to the owner, collaborators and admins.
+	IsPrivate bool `json:"is_private"`
 }
 
 func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
@@ -33,7 +83,28 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		is_private,
+		thumbnail_sha256,
+		video_sha256,
+		source_url,
+		source_sha256,
+		duration_ms,
+		chapters_manual,
+		storyboard_url,
+		storyboard_sha256,
+		preview_url,
+		preview_sha256,
+		preview_mp4_url,
+		preview_mp4_sha256,
+		audio_url,
+		audio_sha256,
+		loudness_lufs,
+		loudness_normalized,
+		watermarked,
+		original_sha256,
+		edited,
+		unedited_sha256
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
@@ -57,6 +128,27 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 			&video.ThumbnailURL,
 			&video.VideoURL,
 			&video.UserID,
+			&video.IsPrivate,
+			&video.ThumbnailSHA256,
+			&video.VideoSHA256,
+			&video.SourceURL,
+			&video.SourceSHA256,
+			&video.DurationMS,
+			&video.ChaptersManual,
+			&video.StoryboardURL,
+			&video.StoryboardSHA256,
+			&video.PreviewURL,
+			&video.PreviewSHA256,
+			&video.PreviewMP4URL,
+			&video.PreviewMP4SHA256,
+			&video.AudioURL,
+			&video.AudioSHA256,
+			&video.LoudnessLUFS,
+			&video.LoudnessNormalized,
+			&video.Watermarked,
+			&video.OriginalSHA256,
+			&video.Edited,
+			&video.UneditedSHA256,
 		); err != nil {
 			return nil, err
 		}
@@ -75,10 +167,11 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		is_private
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.IsPrivate)
 	if err != nil {
 		return Video{}, err
 	}
@@ -96,7 +189,28 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		is_private,
+		thumbnail_sha256,
+		video_sha256,
+		source_url,
+		source_sha256,
+		duration_ms,
+		chapters_manual,
+		storyboard_url,
+		storyboard_sha256,
+		preview_url,
+		preview_sha256,
+		preview_mp4_url,
+		preview_mp4_sha256,
+		audio_url,
+		audio_sha256,
+		loudness_lufs,
+		loudness_normalized,
+		watermarked,
+		original_sha256,
+		edited,
+		unedited_sha256
 	FROM videos
 	WHERE id = ?
 	`
@@ -110,7 +224,28 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		&video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
-		&video.UserID)
+		&video.UserID,
+		&video.IsPrivate,
+		&video.ThumbnailSHA256,
+		&video.VideoSHA256,
+		&video.SourceURL,
+		&video.SourceSHA256,
+		&video.DurationMS,
+		&video.ChaptersManual,
+		&video.StoryboardURL,
+		&video.StoryboardSHA256,
+		&video.PreviewURL,
+		&video.PreviewSHA256,
+		&video.PreviewMP4URL,
+		&video.PreviewMP4SHA256,
+		&video.AudioURL,
+		&video.AudioSHA256,
+		&video.LoudnessLUFS,
+		&video.LoudnessNormalized,
+		&video.Watermarked,
+		&video.OriginalSHA256,
+		&video.Edited,
+		&video.UneditedSHA256)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,7 +256,15 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// ErrVideoChanged is returned by UpdateVideo when the video's file was
+// replaced after the caller read it.
+var ErrVideoChanged = errors.New("video was changed by another request")
+
+// UpdateVideo saves video provided its file is still the one with hash
+// videoSHA256, nil for none, and returns ErrVideoChanged otherwise. Callers
+// release what the video they read referenced, so saving over a concurrent
+// upload or edit would release blobs still in use.
+func (c Client) UpdateVideo(video Video, videoSHA256 *string) error {
 	query := `
 	UPDATE videos
 	SET
@@ -129,27 +272,103 @@ func (c Client) UpdateVideo(video Video) error {
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		user_id = ?,
+		is_private = ?,
+		thumbnail_sha256 = ?,
+		video_sha256 = ?,
+		source_url = ?,
+		source_sha256 = ?,
+		duration_ms = ?,
+		storyboard_url = ?,
+		storyboard_sha256 = ?,
+		preview_url = ?,
+		preview_sha256 = ?,
+		preview_mp4_url = ?,
+		preview_mp4_sha256 = ?,
+		audio_url = ?,
+		audio_sha256 = ?,
+		loudness_lufs = ?,
+		loudness_normalized = ?,
+		watermarked = ?,
+		original_sha256 = ?,
+		edited = ?,
+		unedited_sha256 = ?
+	WHERE id = ? AND video_sha256 IS ?
 	`
 
-	_, err := c.db.Exec(
+	result, err := c.db.Exec(
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.IsPrivate,
+		video.ThumbnailSHA256,
+		video.VideoSHA256,
+		video.SourceURL,
+		video.SourceSHA256,
+		video.DurationMS,
+		video.StoryboardURL,
+		video.StoryboardSHA256,
+		video.PreviewURL,
+		video.PreviewSHA256,
+		video.PreviewMP4URL,
+		video.PreviewMP4SHA256,
+		video.AudioURL,
+		video.AudioSHA256,
+		video.LoudnessLUFS,
+		video.LoudnessNormalized,
+		video.Watermarked,
+		video.OriginalSHA256,
+		video.Edited,
+		video.UneditedSHA256,
 		video.ID,
+		videoSHA256,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVideoChanged
+	}
+	return nil
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_collaborators WHERE video_id = ?", id.String())
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("DELETE FROM video_captions WHERE video_id = ?", id.String())
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("DELETE FROM video_storyboard_sheets WHERE video_id = ?", id.String())
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("DELETE FROM video_edit_segments WHERE video_id = ?", id.String())
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("DELETE FROM video_chapters WHERE video_id = ?", id.String())
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("DELETE FROM transcription_jobs WHERE video_id = ?", id.String())
+	if err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err = c.db.Exec(query, id)
 	return err
 }
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserWatermark is the logo a user's videos are stamped with, overriding
+// the deployment's.
+type UserWatermark struct {
+	UserID      uuid.UUID `json:"user_id"`
+	ImageURL    string    `json:"image_url"`
+	ImageSHA256 string    `json:"image_sha256"`
+	// Position is a corner such as "bottom-right", or "center".
+	Position string `json:"position"`
+	// Opacity runs from just above 0 to 1, fully opaque.
+	Opacity float64 `json:"opacity"`
+	// Margin is the gap to the frame's edges in pixels.
+	Margin    int       `json:"margin"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type UpsertUserWatermarkParams struct {
+	UserID      uuid.UUID
+	ImageURL    string
+	ImageSHA256 string
+	Position    string
+	Opacity     float64
+	Margin      int
+}
+
+func (c Client) UpsertUserWatermark(params UpsertUserWatermarkParams) (UserWatermark, error) {
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO user_watermarks (
+		user_id,
+		image_url,
+		image_sha256,
+		position,
+		opacity,
+		margin,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+		image_url = excluded.image_url,
+		image_sha256 = excluded.image_sha256,
+		position = excluded.position,
+		opacity = excluded.opacity,
+		margin = excluded.margin,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, params.UserID.String(), params.ImageURL, params.ImageSHA256, params.Position, params.Opacity, params.Margin, now, now)
+	if err != nil {
+		return UserWatermark{}, err
+	}
+	return c.GetUserWatermark(params.UserID)
+}
+
+// GetUserWatermark returns a user's watermark, or a zero UserWatermark if
+// they haven't set one.
+func (c Client) GetUserWatermark(userID uuid.UUID) (UserWatermark, error) {
+	query := `
+	SELECT user_id, image_url, image_sha256, position, opacity, margin, created_at, updated_at
+	FROM user_watermarks
+	WHERE user_id = ?
+	`
+	var watermark UserWatermark
+	err := c.db.QueryRow(query, userID.String()).Scan(
+		&watermark.UserID,
+		&watermark.ImageURL,
+		&watermark.ImageSHA256,
+		&watermark.Position,
+		&watermark.Opacity,
+		&watermark.Margin,
+		&watermark.CreatedAt,
+		&watermark.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserWatermark{}, nil
+	}
+	if err != nil {
+		return UserWatermark{}, err
+	}
+	return watermark, nil
+}
+
+func (c Client) DeleteUserWatermark(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM user_watermarks WHERE user_id = ?", userID.String())
+	return err
+}
@@ -0,0 +1,300 @@
+// Package edge is a caching reverse proxy that stands in for a CDN such as
+// CloudFront during local development.
+package edge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTTL is used for origin responses without caching headers,
+	// matching CloudFront's default.
+	DefaultTTL = 24 * time.Hour
+
+	via = "1.1 tubely-edge"
+)
+
+// X-Cache values.
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheRevalidated = "REVALIDATED"
+	cacheStale       = "STALE"
+	cacheBypass      = "BYPASS"
+)
+
+// storedHeaders are the origin response headers kept with a cached body.
+var storedHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Language",
+	"Content-Type",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+}
+
+type Config struct {
+	// Origin is the base URL requests are forwarded to.
+	Origin *url.URL
+	// Dir holds the cached responses.
+	Dir string
+	// MaxBytes bounds the total size of cached bodies.
+	MaxBytes int64
+	// DefaultTTL applies when the origin sends no caching headers. Zero
+	// means DefaultTTL.
+	DefaultTTL time.Duration
+}
+
+// Cache serves GET and HEAD requests from its disk cache, fetching whole
+// objects from the origin on a miss so later range requests for any part
+// of them are hits. Requests carrying credentials and responses the origin
+// marks private or no-store are passed through.
+type Cache struct {
+	origin     *url.URL
+	defaultTTL time.Duration
+	store      *store
+	client     *http.Client
+	proxy      *httputil.ReverseProxy
+}
+
+func New(cfg Config) (*Cache, error) {
+	if cfg.Origin == nil {
+		return nil, errors.New("edge: origin is required")
+	}
+	if cfg.MaxBytes <= 0 {
+		return nil, errors.New("edge: max bytes must be positive")
+	}
+	if cfg.DefaultTTL == 0 {
+		cfg.DefaultTTL = DefaultTTL
+	}
+
+	s, err := openStore(cfg.Dir, cfg.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := cfg.Origin
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(origin)
+			pr.SetXForwarded()
+			pr.Out.Header.Set("Via", via)
+		},
+		ModifyResponse: func(res *http.Response) error {
+			res.Header.Set("X-Cache", cacheBypass)
+			return nil
+		},
+	}
+
+	return &Cache{
+		origin:     origin,
+		defaultTTL: cfg.DefaultTTL,
+		store:      s,
+		client: &http.Client{
+			// Redirects are the client's to follow, and cached like any
+			// other uncacheable response: not at all.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		proxy: proxy,
+	}, nil
+}
+
+// Purge drops cached objects whose path matches one of patterns, which
+// are exact paths or prefixes ending in "*", and returns how many were
+// removed.
+func (c *Cache) Purge(patterns []string) int {
+	return c.store.purge(patterns)
+}
+
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// A request that already went through the edge means the origin
+	// points back at us.
+	if strings.Contains(r.Header.Get("Via"), via) {
+		http.Error(w, "edge: request loop detected", http.StatusLoopDetected)
+		return
+	}
+
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Authorization") != "" {
+		c.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	key := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		key += "?" + r.URL.RawQuery
+	}
+
+	now := time.Now().UTC()
+	e, ok := c.store.get(key)
+	if ok && e.fresh(now) {
+		c.serveEntry(w, r, e, cacheHit)
+		return
+	}
+
+	res, err := c.fetch(r, e)
+	if err != nil {
+		log.Printf("edge: couldn't reach origin for %s: %v", key, err)
+		if e != nil {
+			// Stale is better than nothing when the origin is down.
+			c.serveEntry(w, r, e, cacheStale)
+			return
+		}
+		http.Error(w, "edge: origin unreachable", http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	policy := policyFor(res.Header, now, c.defaultTTL)
+
+	if res.StatusCode == http.StatusNotModified && e != nil {
+		refreshed, err := c.store.refresh(key, res.Header, now, now.Add(policy.ttl))
+		if err != nil {
+			log.Printf("edge: couldn't refresh %s: %v", key, err)
+			refreshed = e
+		}
+		c.serveEntry(w, r, refreshed, cacheRevalidated)
+		return
+	}
+
+	if res.StatusCode != http.StatusOK {
+		copyResponse(w, res, cacheBypass)
+		return
+	}
+
+	f, err := c.store.tempFile()
+	if err != nil {
+		log.Printf("edge: couldn't create cache file: %v", err)
+		copyResponse(w, res, cacheBypass)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, res.Body)
+	if err != nil {
+		http.Error(w, "edge: couldn't read origin response", http.StatusBadGateway)
+		return
+	}
+
+	fetched := &entry{
+		Key:      key,
+		Header:   http.Header{},
+		Size:     size,
+		StoredAt: now,
+		Expires:  now.Add(policy.ttl),
+	}
+	for _, name := range storedHeaders {
+		if values := res.Header.Values(name); len(values) > 0 {
+			fetched.Header[name] = values
+		}
+	}
+
+	status := cacheMiss
+	if policy.store {
+		err = c.store.put(fetched, f.Name())
+		if err != nil {
+			log.Printf("edge: couldn't cache %s: %v", key, err)
+			status = cacheBypass
+		}
+	} else {
+		status = cacheBypass
+	}
+
+	// The body is served from the file just written, which lets
+	// ServeContent answer the client's range and conditional headers.
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		http.Error(w, "edge: couldn't read cached response", http.StatusInternalServerError)
+		return
+	}
+	writeEntryHeaders(w, fetched, now, status)
+	http.ServeContent(w, r, "", lastModified(fetched), f)
+}
+
+// fetch requests the whole object from the origin, revalidating stale
+// when there is a cached copy with validators.
+func (c *Cache) fetch(r *http.Request, stale *entry) (*http.Response, error) {
+	target := c.origin.JoinPath(r.URL.EscapedPath())
+	target.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"Accept", "Accept-Language", "User-Agent"} {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	req.Header.Set("Via", via)
+	if stale != nil {
+		if etag := stale.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := stale.Header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+	return c.client.Do(req)
+}
+
+func (c *Cache) serveEntry(w http.ResponseWriter, r *http.Request, e *entry, status string) {
+	f, err := os.Open(c.store.bodyPath(e.Key))
+	if err != nil {
+		// Evicted between lookup and open.
+		http.Error(w, "edge: cached response disappeared, retry", http.StatusServiceUnavailable)
+		return
+	}
+	defer f.Close()
+
+	writeEntryHeaders(w, e, time.Now().UTC(), status)
+	http.ServeContent(w, r, "", lastModified(e), f)
+}
+
+func writeEntryHeaders(w http.ResponseWriter, e *entry, now time.Time, status string) {
+	for name, values := range e.Header {
+		w.Header()[name] = values
+	}
+	age := int64(now.Sub(e.StoredAt) / time.Second)
+	if age < 0 {
+		age = 0
+	}
+	w.Header().Set("Age", strconv.FormatInt(age, 10))
+	w.Header().Set("Via", via)
+	w.Header().Set("X-Cache", status)
+}
+
+func lastModified(e *entry) time.Time {
+	t, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// copyResponse relays an origin response the edge won't cache.
+func copyResponse(w http.ResponseWriter, res *http.Response, status string) {
+	for name, values := range res.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Via", via)
+	w.Header().Set("X-Cache", status)
+	w.WriteHeader(res.StatusCode)
+	_, err := io.Copy(w, res.Body)
+	if err != nil {
+		log.Printf("edge: %v", fmt.Errorf("couldn't relay origin response: %w", err))
+	}
+}
@@ -0,0 +1,71 @@
+package edge
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cachePolicy is what the origin's response headers allow the edge to do
+// with it.
+type cachePolicy struct {
+	// store is false for no-store and private responses.
+	store bool
+	// ttl is how long a stored copy may be served without revalidating.
+	ttl time.Duration
+}
+
+// policyFor reads Cache-Control and Expires the way a shared cache does:
+// s-maxage wins over max-age, no-cache means store but always revalidate,
+// and responses that say nothing get defaultTTL like CloudFront's default
+// cache behavior.
+func policyFor(header http.Header, now time.Time, defaultTTL time.Duration) cachePolicy {
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return cachePolicy{}
+	}
+	if _, ok := directives["private"]; ok {
+		return cachePolicy{}
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return cachePolicy{store: true}
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		value, ok := directives[name]
+		if !ok {
+			continue
+		}
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds < 0 {
+			return cachePolicy{store: true}
+		}
+		return cachePolicy{store: true, ttl: time.Duration(seconds) * time.Second}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil || !t.After(now) {
+			return cachePolicy{store: true}
+		}
+		return cachePolicy{store: true, ttl: t.Sub(now)}
+	}
+
+	return cachePolicy{store: true, ttl: defaultTTL}
+}
+
+// parseCacheControl splits a Cache-Control header into lower-cased
+// directive names and their unquoted values.
+func parseCacheControl(value string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return directives
+}
@@ -0,0 +1,259 @@
+package edge
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bodyExt = ".body"
+	metaExt = ".json"
+)
+
+// entry describes a cached response. The body lives next to the metadata
+// file, both named after the hash of the key.
+type entry struct {
+	Key      string      `json:"key"`
+	Header   http.Header `json:"header"`
+	Size     int64       `json:"size"`
+	StoredAt time.Time   `json:"stored_at"`
+	Expires  time.Time   `json:"expires"`
+}
+
+func (e *entry) fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// store is a size-bounded on-disk cache evicting the least recently used
+// entries first. Entries survive restarts; recency does not, so after a
+// restart eviction falls back to the order entries were stored in.
+type store struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+func openStore(dir string, maxBytes int64) (*store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := &store{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+metaExt))
+	if err != nil {
+		return nil, err
+	}
+	entries := []*entry{}
+	for _, path := range paths {
+		dat, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		e := &entry{}
+		err = json.Unmarshal(dat, e)
+		if err != nil {
+			log.Printf("Dropping unreadable edge cache entry %s: %v", path, err)
+			s.removeFiles(strings.TrimSuffix(filepath.Base(path), metaExt))
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StoredAt.Before(entries[j].StoredAt)
+	})
+	for _, e := range entries {
+		s.items[e.Key] = s.lru.PushFront(e)
+		s.size += e.Size
+	}
+	s.evict()
+
+	// Bodies that were being written when the server stopped.
+	temps, _ := filepath.Glob(filepath.Join(dir, "tmp-*"))
+	for _, path := range temps {
+		os.Remove(path)
+	}
+	return s, nil
+}
+
+func fileID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *store) bodyPath(key string) string {
+	return filepath.Join(s.dir, fileID(key)+bodyExt)
+}
+
+// get returns the entry for key and marks it as recently used.
+func (s *store) get(key string) (*entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	e := *el.Value.(*entry)
+	return &e, true
+}
+
+// tempFile creates a file to write a body into before it is committed.
+func (s *store) tempFile() (*os.File, error) {
+	return os.CreateTemp(s.dir, "tmp-*")
+}
+
+// put moves the body at tempPath into the cache under e.Key, replacing any
+// previous entry, and evicts old entries to stay within the size limit.
+func (s *store) put(e *entry, tempPath string) error {
+	if e.Size > s.maxBytes {
+		os.Remove(tempPath)
+		return fmt.Errorf("response of %d bytes exceeds cache size", e.Size)
+	}
+
+	dat, err := json.Marshal(e)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := fileID(e.Key)
+	err = os.Rename(tempPath, filepath.Join(s.dir, id+bodyExt))
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	err = os.WriteFile(filepath.Join(s.dir, id+metaExt), dat, 0644)
+	if err != nil {
+		return err
+	}
+
+	if el, ok := s.items[e.Key]; ok {
+		s.size -= el.Value.(*entry).Size
+		s.lru.Remove(el)
+	}
+	s.items[e.Key] = s.lru.PushFront(e)
+	s.size += e.Size
+	s.evict()
+	return nil
+}
+
+// refresh updates a stored entry's headers and expiry after the origin
+// confirmed at validatedAt that it is still current.
+func (s *store) refresh(key string, header http.Header, validatedAt, expires time.Time) (*entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, fmt.Errorf("no cache entry for %s", key)
+	}
+	e := el.Value.(*entry)
+	// Readers hold copies sharing the old header map, so replace it
+	// rather than editing it in place.
+	updated := e.Header.Clone()
+	for name, values := range header {
+		if _, ok := updated[name]; ok {
+			updated[name] = values
+		}
+	}
+	e.Header = updated
+	e.StoredAt = validatedAt
+	e.Expires = expires
+
+	dat, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(s.dir, fileID(key)+metaExt), dat, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.lru.MoveToFront(el)
+	copied := *e
+	return &copied, nil
+}
+
+// purge removes every entry whose key matches one of the patterns and
+// returns how many were removed.
+func (s *store) purge(patterns []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := 0
+	for key, el := range s.items {
+		if !matchesAny(key, patterns) {
+			continue
+		}
+		s.removeElement(el)
+		purged++
+	}
+	return purged
+}
+
+// evict drops least recently used entries until the cache fits. Callers
+// must hold s.mu.
+func (s *store) evict() {
+	for s.size > s.maxBytes {
+		el := s.lru.Back()
+		if el == nil {
+			return
+		}
+		s.removeElement(el)
+	}
+}
+
+// removeElement forgets an entry and deletes its files. Open readers keep
+// working since the files are only unlinked. Callers must hold s.mu.
+func (s *store) removeElement(el *list.Element) {
+	e := el.Value.(*entry)
+	s.lru.Remove(el)
+	delete(s.items, e.Key)
+	s.size -= e.Size
+	s.removeFiles(fileID(e.Key))
+}
+
+func (s *store) removeFiles(id string) {
+	os.Remove(filepath.Join(s.dir, id+bodyExt))
+	os.Remove(filepath.Join(s.dir, id+metaExt))
+}
+
+// matchesAny reports whether the path part of key matches a pattern.
+// Patterns follow CloudFront invalidation paths: an exact path, or a
+// prefix ending in "*".
+func matchesAny(key string, patterns []string) bool {
+	path, _, _ := strings.Cut(key, "?")
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+			continue
+		}
+		if path == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is a MediaTool that doesn't run anything, for tests. Outputs are
+// written as small placeholder files holding their own path, so distinct
+// outputs have distinct contents.
+type Fake struct {
+	// ProbeResult is returned by Probe for every file.
+	ProbeResult ProbeResult
+	// KeyframeTimes is returned by Keyframes for every file.
+	KeyframeTimes []time.Duration
+	// Log is returned by Transcode as what ffmpeg logged.
+	Log string
+	// Err, when set, is returned by every call instead.
+	Err error
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Call is a recorded call to a Fake.
+type Call struct {
+	// Method is the MediaTool method called, e.g. "Transcode".
+	Method string
+	Args   []string
+	// RemuxOptions are the options a Remux call was given.
+	RemuxOptions RemuxOptions
+}
+
+func (f *Fake) record(method string, args ...string) error {
+	return f.recordCall(Call{Method: method, Args: args})
+}
+
+func (f *Fake) recordCall(call Call) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+	return f.Err
+}
+
+// Calls returns the calls made so far, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+func (f *Fake) Probe(ctx context.Context, path string) (ProbeResult, error) {
+	if err := f.record("Probe", path); err != nil {
+		return ProbeResult{}, err
+	}
+	return f.ProbeResult, nil
+}
+
+func (f *Fake) Keyframes(ctx context.Context, path string) ([]time.Duration, error) {
+	if err := f.record("Keyframes", path); err != nil {
+		return nil, err
+	}
+	return append([]time.Duration(nil), f.KeyframeTimes...), nil
+}
+
+func (f *Fake) Remux(ctx context.Context, input, output string, opts RemuxOptions) error {
+	if err := f.recordCall(Call{Method: "Remux", Args: []string{input, output}, RemuxOptions: opts}); err != nil {
+		return err
+	}
+	return writePlaceholder(output)
+}
+
+// Transcode takes the last argument as the output. An image sequence
+// pattern such as "frame-%04d.jpg" gets its first file.
+func (f *Fake) Transcode(ctx context.Context, args ...string) (string, error) {
+	if err := f.record("Transcode", args...); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "", fmt.Errorf("ffmpeg: no output")
+	}
+	output := args[len(args)-1]
+	if output == "-" {
+		return f.Log, nil
+	}
+	if strings.Contains(output, "%") {
+		output = fmt.Sprintf(output, 1)
+	}
+	return f.Log, writePlaceholder(output)
+}
+
+func (f *Fake) ExtractFrame(ctx context.Context, input string, offset time.Duration, output string) error {
+	if err := f.record("ExtractFrame", input, offset.String(), output); err != nil {
+		return err
+	}
+	return writePlaceholder(output)
+}
+
+func writePlaceholder(path string) error {
+	return os.WriteFile(path, []byte(path), 0o644)
+}
@@ -0,0 +1,275 @@
+// Package ffmpeg runs the ffmpeg and ffprobe tools that media is probed
+// and processed with.
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaTool probes and processes media files.
+type MediaTool interface {
+	// Probe describes a file's container and streams.
+	Probe(ctx context.Context, path string) (ProbeResult, error)
+	// Keyframes lists when the keyframes of a file's first video stream
+	// are, in order.
+	Keyframes(ctx context.Context, path string) ([]time.Duration, error)
+	// Remux copies the streams of input into a new container at output,
+	// without re-encoding.
+	Remux(ctx context.Context, input, output string, opts RemuxOptions) error
+	// Transcode runs ffmpeg with args, which name the inputs, filters,
+	// encoders and output. It returns what ffmpeg logged, which is where
+	// analysis filters such as loudnorm report.
+	Transcode(ctx context.Context, args ...string) (string, error)
+	// ExtractFrame decodes the frame of input's first video stream at
+	// offset and writes it as an image to output, in the format its
+	// extension names. Any decoding error fails it.
+	ExtractFrame(ctx context.Context, input string, offset time.Duration, output string) error
+}
+
+// RemuxOptions shape a remuxed file.
+type RemuxOptions struct {
+	// Format is the output container, e.g. "mp4".
+	Format string
+	// FastStart moves an MP4's index to the front, so playback can start
+	// before the whole file has downloaded.
+	FastStart bool
+}
+
+// ProbeResult is what ffprobe reports about a file.
+type ProbeResult struct {
+	Streams []Stream `json:"streams"`
+	Format  Format   `json:"format"`
+}
+
+type Stream struct {
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	PixFmt    string `json:"pix_fmt"`
+}
+
+// Format describes the container. ffprobe reports numbers as strings
+// here.
+type Format struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+}
+
+// Error is a failed run of ffmpeg or ffprobe.
+type Error struct {
+	// Tool is the binary that was run.
+	Tool string
+	Args []string
+	// ExitCode is the tool's exit status, or -1 if it didn't exit by
+	// itself: it couldn't start, or was killed for running too long.
+	ExitCode int
+	// Stderr is the end of what the tool logged.
+	Stderr string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v: %s", filepath.Base(e.Tool), e.Err, e.Summary())
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Rejected reports whether the tool ran to completion and refused its
+// input, which usually means the file is broken or unsupported.
+func (e *Error) Rejected() bool {
+	return e.ExitCode > 0
+}
+
+// Summary is the first line the tool logged, usually the one that says
+// what went wrong.
+func (e *Error) Summary() string {
+	s := strings.TrimSpace(e.Stderr)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return "unknown error"
+	}
+	return s
+}
+
+// maxStderr bounds how much of a tool's log is kept. The end is kept:
+// analysis filters report there.
+const maxStderr = 64 << 10
+
+// tailBuffer keeps the last maxStderr bytes written to it.
+type tailBuffer struct {
+	buf bytes.Buffer
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > maxStderr {
+		p = p[len(p)-maxStderr:]
+	}
+	if over := t.buf.Len() + len(p) - maxStderr; over > 0 {
+		t.buf.Next(over)
+	}
+	t.buf.Write(p)
+	return n, nil
+}
+
+// ExecConfig configures Exec.
+type ExecConfig struct {
+	// FFmpeg and FFprobe are the binaries to run. Empty means "ffmpeg"
+	// and "ffprobe" on the PATH.
+	FFmpeg  string
+	FFprobe string
+	// Timeout bounds each run; zero leaves runs to their context.
+	Timeout time.Duration
+	// Threads caps the threads ffmpeg uses for filters and encoding in
+	// each run; zero leaves it to ffmpeg, which uses every core.
+	Threads int
+	// MaxConcurrent is how many runs may go at once; zero is no limit.
+	// Others wait their turn.
+	MaxConcurrent int
+}
+
+// Exec runs the ffmpeg and ffprobe binaries.
+type Exec struct {
+	cfg   ExecConfig
+	slots chan struct{}
+}
+
+func NewExec(cfg ExecConfig) *Exec {
+	if cfg.FFmpeg == "" {
+		cfg.FFmpeg = "ffmpeg"
+	}
+	if cfg.FFprobe == "" {
+		cfg.FFprobe = "ffprobe"
+	}
+	e := &Exec{cfg: cfg}
+	if cfg.MaxConcurrent > 0 {
+		e.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return e
+}
+
+// run runs a tool within the configured limits, returning its output and
+// what it logged.
+func (e *Exec) run(ctx context.Context, tool string, args ...string) ([]byte, string, error) {
+	if e.slots != nil {
+		select {
+		case e.slots <- struct{}{}:
+			defer func() { <-e.slots }()
+		case <-ctx.Done():
+			return nil, "", &Error{Tool: tool, Args: args, ExitCode: -1, Err: ctx.Err()}
+		}
+	}
+	if e.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.cfg.Timeout)
+		defer cancel()
+	}
+
+	command := exec.CommandContext(ctx, tool, args...)
+	var stdout bytes.Buffer
+	var stderr tailBuffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Run()
+	if err != nil {
+		toolErr := &Error{Tool: tool, Args: args, ExitCode: -1, Stderr: stderr.buf.String(), Err: err}
+		var exitErr *exec.ExitError
+		if ctx.Err() != nil {
+			toolErr.Err = ctx.Err()
+		} else if errors.As(err, &exitErr) {
+			toolErr.ExitCode = exitErr.ExitCode()
+		}
+		return nil, "", toolErr
+	}
+	return stdout.Bytes(), stderr.buf.String(), nil
+}
+
+// ffmpeg runs ffmpeg with args, applying the thread cap to the output,
+// which comes last.
+func (e *Exec) ffmpeg(ctx context.Context, args ...string) (string, error) {
+	full := []string{"-nostdin"}
+	if e.cfg.Threads > 0 && len(args) > 0 {
+		threads := strconv.Itoa(e.cfg.Threads)
+		full = append(full, "-filter_threads", threads)
+		full = append(full, args[:len(args)-1]...)
+		full = append(full, "-threads", threads, args[len(args)-1])
+	} else {
+		full = append(full, args...)
+	}
+	_, log, err := e.run(ctx, e.cfg.FFmpeg, full...)
+	return log, err
+}
+
+func (e *Exec) Probe(ctx context.Context, path string) (ProbeResult, error) {
+	stdout, _, err := e.run(ctx, e.cfg.FFprobe, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	var result ProbeResult
+	err = json.Unmarshal(stdout, &result)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("ffprobe: bad output: %w", err)
+	}
+	return result, nil
+}
+
+func (e *Exec) Keyframes(ctx context.Context, path string) ([]time.Duration, error) {
+	stdout, _, err := e.run(ctx, e.cfg.FFprobe, "-v", "error", "-select_streams", "v:0",
+		"-skip_frame", "nokey", "-show_entries", "frame=pts_time", "-of", "csv=p=0", path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyframes := []time.Duration{}
+	for _, line := range strings.Split(string(stdout), "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), ","))
+		if line == "" || line == "N/A" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ffprobe: bad keyframe time %q", line)
+		}
+		keyframes = append(keyframes, time.Duration(seconds*float64(time.Second)))
+	}
+	return keyframes, nil
+}
+
+func (e *Exec) Remux(ctx context.Context, input, output string, opts RemuxOptions) error {
+	args := []string{"-v", "error", "-y", "-i", input, "-c", "copy"}
+	if opts.FastStart {
+		args = append(args, "-movflags", "faststart")
+	}
+	if opts.Format != "" {
+		args = append(args, "-f", opts.Format)
+	}
+	args = append(args, output)
+	_, err := e.ffmpeg(ctx, args...)
+	return err
+}
+
+func (e *Exec) Transcode(ctx context.Context, args ...string) (string, error) {
+	return e.ffmpeg(ctx, args...)
+}
+
+func (e *Exec) ExtractFrame(ctx context.Context, input string, offset time.Duration, output string) error {
+	_, err := e.ffmpeg(ctx, "-v", "error", "-xerror", "-y",
+		"-ss", fmt.Sprintf("%.3f", offset.Seconds()), "-i", input,
+		"-map", "0:v:0", "-frames:v", "1", "-q:v", "3", "-update", "1", output)
+	return err
+}
@@ -0,0 +1,167 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// minKeyRefresh limits how often an unknown kid can trigger a JWKS fetch.
+const minKeyRefresh = time.Minute
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// keySet caches the provider's signing keys and refetches them when a
+// token names a kid it hasn't seen, which is how providers roll keys.
+type keySet struct {
+	uri     string
+	getJSON func(ctx context.Context, url string, v any) error
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	algs      map[string]string
+	fetchedAt time.Time
+}
+
+func newKeySet(uri string, getJSON func(ctx context.Context, url string, v any) error) *keySet {
+	return &keySet{uri: uri, getJSON: getJSON}
+}
+
+func (ks *keySet) key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.lookup(kid)
+	if !ok && time.Since(ks.fetchedAt) > minKeyRefresh {
+		err := ks.refresh(ctx)
+		if err != nil {
+			return nil, err
+		}
+		key, ok = ks.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if want := ks.algs[kid]; want != "" && want != alg {
+		return nil, fmt.Errorf("key %q is for %s, token uses %s", kid, want, alg)
+	}
+	return key, nil
+}
+
+// lookup finds kid, or the only key when the token has no kid.
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *keySet) refresh(ctx context.Context) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	ks.fetchedAt = time.Now()
+	err := ks.getJSON(ctx, ks.uri, &set)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch provider keys: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	algs := map[string]string{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip key types we don't understand rather than failing
+			// logins signed with the ones we do.
+			continue
+		}
+		keys[k.KeyID] = key
+		algs[k.KeyID] = k.Algorithm
+	}
+	ks.keys = keys
+	ks.algs = algs
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,143 @@
+// Package transcribe turns speech into WebVTT captions.
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AutoDetect asks a Transcriber to work out the spoken language itself.
+const AutoDetect = "auto"
+
+// Result is a finished transcription.
+type Result struct {
+	// VTT is the transcript as WebVTT captions.
+	VTT []byte
+	// Language is the spoken language, as detected or as requested.
+	Language string
+}
+
+// Transcriber transcribes speech. audioPath is a 16 kHz mono WAV file, the
+// format speech models are trained on; language is an ISO 639-1 code or
+// AutoDetect.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audioPath, language string) (Result, error)
+}
+
+// WhisperCPP runs whisper.cpp's command line tool on the CPU.
+type WhisperCPP struct {
+	// Binary is the whisper.cpp executable, e.g. "whisper-cli".
+	Binary string
+	// Model is the path to a ggml model file.
+	Model string
+	// Threads is how many CPU threads to use; zero uses whisper.cpp's
+	// default.
+	Threads int
+}
+
+var whisperDetectedLanguage = regexp.MustCompile(`auto-detected language: ([a-z]{2,3})`)
+
+func (w WhisperCPP) Transcribe(ctx context.Context, audioPath, language string) (Result, error) {
+	dir, err := os.MkdirTemp("", "tubely-whisper")
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	// whisper.cpp appends the format's extension to the output prefix.
+	outputPrefix := filepath.Join(dir, "captions")
+	args := []string{"-m", w.Model, "-f", audioPath, "-l", language, "-ovtt", "-of", outputPrefix}
+	if w.Threads > 0 {
+		args = append(args, "-t", strconv.Itoa(w.Threads))
+	}
+
+	command := exec.CommandContext(ctx, w.Binary, args...)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	err = command.Run()
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w: %s", w.Binary, err, lastLine(stderr.String()))
+	}
+
+	vtt, err := os.ReadFile(outputPrefix + ".vtt")
+	if err != nil {
+		return Result{}, fmt.Errorf("%s didn't write captions: %w", w.Binary, err)
+	}
+
+	if language == AutoDetect {
+		language = ""
+		if match := whisperDetectedLanguage.FindStringSubmatch(stderr.String()); match != nil {
+			language = match[1]
+		}
+	}
+	return Result{VTT: vtt, Language: language}, nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+// Fake is a Transcriber that returns a canned transcript without running
+// a model, for tests.
+type Fake struct {
+	// Result is returned as is, except that an empty VTT is replaced with
+	// a placeholder cue and an empty Language with the requested one, or
+	// "en" when detection was asked for.
+	Result Result
+	// Err, when set, is returned instead.
+	Err error
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Call is a recorded call to a Fake.
+type Call struct {
+	AudioPath string
+	Language  string
+}
+
+func (f *Fake) Transcribe(ctx context.Context, audioPath, language string) (Result, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, Call{AudioPath: audioPath, Language: language})
+	f.mu.Unlock()
+
+	if f.Err != nil {
+		return Result{}, f.Err
+	}
+	if _, err := os.Stat(audioPath); err != nil {
+		return Result{}, errors.Join(errors.New("fake transcriber needs an audio file"), err)
+	}
+
+	result := f.Result
+	if len(result.VTT) == 0 {
+		result.VTT = []byte("WEBVTT\n\n00:00:00.000 --> 00:00:05.000\n[automatic captions]\n")
+	}
+	if result.Language == "" {
+		result.Language = language
+		if language == AutoDetect {
+			result.Language = "en"
+		}
+	}
+	return result, nil
+}
+
+// Calls returns the calls made so far, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
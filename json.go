@@ -7,6 +7,12 @@ import (
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	respondWithErrorCode(w, code, "", msg, err)
+}
+
+// respondWithErrorCode is respondWithError with a machine-readable error
+// code for failures clients are expected to handle.
+func respondWithErrorCode(w http.ResponseWriter, code int, errCode, msg string, err error) {
 	if err != nil {
 		log.Println(err)
 	}
@@ -15,9 +21,11 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	}
 	type errorResponse struct {
 		Error string `json:"error"`
+		Code  string `json:"code,omitempty"`
 	}
 	respondWithJSON(w, code, errorResponse{
 		Error: msg,
+		Code:  errCode,
 	})
 }
 
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// Failures older than this no longer count towards a lockout.
+	loginFailureWindow = 24 * time.Hour
+	// Free attempts before backoff kicks in. IPs get more room since
+	// several users can share one address.
+	accountFailuresAllowed = 5
+	ipFailuresAllowed      = 20
+	// The first lockout lasts loginBackoffBase and doubles with every
+	// further failure, up to loginBackoffMax.
+	loginBackoffBase = 30 * time.Second
+	loginBackoffMax  = time.Hour
+)
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHash     string
+)
+
+// checkPasswordForUnknownUser spends the same bcrypt work a real password
+// check would, so response times don't reveal which emails are registered.
+func checkPasswordForUnknownUser(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		hash, err := auth.HashPassword(uuid.NewString())
+		if err != nil {
+			log.Printf("Couldn't create dummy password hash: %v", err)
+			return
+		}
+		dummyPasswordHash = hash
+	})
+	auth.CheckPasswordHash(password, dummyPasswordHash)
+}
+
+func loginBackoff(failures, allowed int) time.Duration {
+	if failures < allowed {
+		return 0
+	}
+	backoff := loginBackoffBase
+	for i := allowed; i < failures && backoff < loginBackoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, loginBackoffMax)
+}
+
+// loginLockedUntil reports when the email or IP may try again, or the zero
+// time if neither is locked out.
+func (cfg *apiConfig) loginLockedUntil(email, ip string) (time.Time, error) {
+	since := time.Now().UTC().Add(-loginFailureWindow)
+
+	byEmail, err := cfg.db.GetLoginFailuresByEmail(email, since)
+	if err != nil {
+		return time.Time{}, err
+	}
+	byIP, err := cfg.db.GetLoginFailuresByIP(ip, since)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var lockedUntil time.Time
+	if backoff := loginBackoff(byEmail.Count, accountFailuresAllowed); backoff > 0 {
+		lockedUntil = byEmail.Last.Add(backoff)
+	}
+	if backoff := loginBackoff(byIP.Count, ipFailuresAllowed); backoff > 0 {
+		if until := byIP.Last.Add(backoff); until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+	if !lockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return lockedUntil, nil
+}
+
+// rejectLockedLogin responds with 429 and returns true if the email or IP
+// is currently locked out.
+func (cfg *apiConfig) rejectLockedLogin(w http.ResponseWriter, email, ip string) bool {
+	lockedUntil, err := cfg.loginLockedUntil(email, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return true
+	}
+	if lockedUntil.IsZero() {
+		return false
+	}
+
+	cfg.recordLoginAttempt(email, ip, nil, database.LoginRejectedLocked)
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later", nil)
+	return true
+}
+
+func (cfg *apiConfig) recordLoginAttempt(email, ip string, userID *uuid.UUID, result database.LoginAttemptResult) {
+	err := cfg.db.CreateLoginAttempt(database.CreateLoginAttemptParams{
+		Email:  email,
+		IP:     ip,
+		UserID: userID,
+		Result: result,
+	})
+	if err != nil {
+		log.Printf("Couldn't record login attempt for %s from %s: %v", email, ip, err)
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestLoginLockedUntilIgnoresSuccessForIP(t *testing.T) {
+	cfg := newTestConfig(t)
+	const ip = "203.0.113.7"
+
+	// Signing in to an account of one's own between guesses at another
+	// mustn't clear the IP's failures.
+	for range ipFailuresAllowed {
+		cfg.recordLoginAttempt("victim@example.com", ip, nil, database.LoginBadPassword)
+		cfg.recordLoginAttempt("attacker@example.com", ip, nil, database.LoginSucceeded)
+	}
+
+	lockedUntil, err := cfg.loginLockedUntil("other@example.com", ip)
+	if err != nil {
+		t.Fatalf("loginLockedUntil: %v", err)
+	}
+	if lockedUntil.IsZero() {
+		t.Error("IP isn't locked out after its successes")
+	}
+
+	// Succeeding still resets the account's own count.
+	cfg.recordLoginAttempt("victim@example.com", "198.51.100.1", nil, database.LoginSucceeded)
+	lockedUntil, err = cfg.loginLockedUntil("victim@example.com", "198.51.100.1")
+	if err != nil {
+		t.Fatalf("loginLockedUntil: %v", err)
+	}
+	if !lockedUntil.IsZero() {
+		t.Errorf("account locked until %s after signing in", lockedUntil)
+	}
+}
+
+func TestTOTPDisableSharesLoginLockout(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, token := newTestUser(t, cfg, "owner@example.com")
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		t.Fatalf("MakeTOTPSecret: %v", err)
+	}
+	err = cfg.db.SetPendingTOTP(user.ID, secret)
+	if err != nil {
+		t.Fatalf("SetPendingTOTP: %v", err)
+	}
+	err = cfg.db.EnableTOTP(user.ID, 0, nil)
+	if err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+
+	for i := range accountFailuresAllowed + 1 {
+		r := httptest.NewRequest("POST", "/api/totp/disable", strings.NewReader(`{"code": "wrong"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		cfg.handlerTOTPDisable(rr, r)
+
+		want := http.StatusUnauthorized
+		if i == accountFailuresAllowed {
+			want = http.StatusTooManyRequests
+		}
+		if rr.Code != want {
+			t.Fatalf("attempt %d: status = %d, want %d: %s", i+1, rr.Code, want, rr.Body)
+		}
+	}
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserTOTP: %v", err)
+	}
+	if totp == nil {
+		t.Error("guessing disabled two-factor authentication")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+// Loudness targets, in LUFS and dBTP. The default suits listening on the
+// web; broadcast EBU R128 is -23 LUFS.
+const (
+	defaultLoudnessTarget = -16.0
+	minLoudnessTarget     = -70.0
+	maxLoudnessTarget     = -5.0
+	loudnessTruePeak      = -1.5
+	loudnessRange         = 11.0
+	// loudnessTolerance is how far off target audio may be, in LU, before
+	// normalizing is worth a re-encode.
+	loudnessTolerance = 1.0
+	// loudnormSampleRate undoes loudnorm's upsampling to 192 kHz.
+	loudnormSampleRate = "48000"
+)
+
+// errSilentAudio means the audio has no measurable loudness.
+var errSilentAudio = errors.New("audio is silent")
+
+// loudnessMeasurement is what loudnorm's first pass reports. The second
+// pass needs all of it to normalize in one linear gain change.
+type loudnessMeasurement struct {
+	IntegratedLUFS float64
+	TruePeak       float64
+	Range          float64
+	Threshold      float64
+	TargetOffset   float64
+}
+
+// parseLoudnessTarget reads LOUDNESS_TARGET, in LUFS.
+func parseLoudnessTarget(s string) (float64, error) {
+	if s == "" {
+		return defaultLoudnessTarget, nil
+	}
+	target, err := strconv.ParseFloat(s, 64)
+	if err != nil || target < minLoudnessTarget || target > maxLoudnessTarget {
+		return 0, fmt.Errorf("must be between %g and %g LUFS", minLoudnessTarget, maxLoudnessTarget)
+	}
+	return target, nil
+}
+
+// loudnormFilter is the loudnorm filter aiming at target; with a
+// measurement it is the second, linear pass.
+func loudnormFilter(target float64, measured *loudnessMeasurement) string {
+	filter := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", target, loudnessTruePeak, loudnessRange)
+	if measured == nil {
+		return filter + ":print_format=json"
+	}
+	return filter + fmt.Sprintf(":measured_I=%.2f:measured_TP=%.2f:measured_LRA=%.2f:measured_thresh=%.2f:offset=%.2f:linear=true:print_format=summary",
+		measured.IntegratedLUFS, measured.TruePeak, measured.Range, measured.Threshold, measured.TargetOffset)
+}
+
+// measureLoudness runs loudnorm's analysis pass, an EBU R128 measurement,
+// over a video's first audio track.
+func measureLoudness(ctx context.Context, media ffmpeg.MediaTool, path string, target float64) (loudnessMeasurement, error) {
+	output, err := media.Transcode(ctx, "-hide_banner", "-nostats", "-i", path,
+		"-map", "0:a:0", "-af", loudnormFilter(target, nil), "-f", "null", "-")
+	if err != nil {
+		return loudnessMeasurement{}, err
+	}
+	return parseLoudnormOutput(output)
+}
+
+// parseLoudnormOutput picks the JSON loudnorm prints at the end of its
+// log. Values are strings, and silence measures "-inf".
+func parseLoudnormOutput(output string) (loudnessMeasurement, error) {
+	start, end := strings.LastIndex(output, "{"), strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return loudnessMeasurement{}, errors.New("ffmpeg: no loudnorm measurement in output")
+	}
+	var raw struct {
+		InputI       string `json:"input_i"`
+		InputTP      string `json:"input_tp"`
+		InputLRA     string `json:"input_lra"`
+		InputThresh  string `json:"input_thresh"`
+		TargetOffset string `json:"target_offset"`
+	}
+	err := json.Unmarshal([]byte(output[start:end+1]), &raw)
+	if err != nil {
+		return loudnessMeasurement{}, fmt.Errorf("ffmpeg: bad loudnorm measurement: %w", err)
+	}
+
+	var m loudnessMeasurement
+	for _, field := range []struct {
+		value string
+		dst   *float64
+	}{
+		{raw.InputI, &m.IntegratedLUFS},
+		{raw.InputTP, &m.TruePeak},
+		{raw.InputLRA, &m.Range},
+		{raw.InputThresh, &m.Threshold},
+		{raw.TargetOffset, &m.TargetOffset},
+	} {
+		*field.dst, err = strconv.ParseFloat(strings.TrimSpace(field.value), 64)
+		if err != nil {
+			return loudnessMeasurement{}, fmt.Errorf("ffmpeg: bad loudnorm value %q", field.value)
+		}
+	}
+	if math.IsInf(m.IntegratedLUFS, 0) || math.IsInf(m.Threshold, 0) {
+		return loudnessMeasurement{}, errSilentAudio
+	}
+	return m, nil
+}
+
+// needsNormalizing reports whether measured audio is far enough from
+// target, or peaks high enough, to be worth normalizing.
+func needsNormalizing(m loudnessMeasurement, target float64) bool {
+	return math.Abs(m.IntegratedLUFS-target) > loudnessTolerance || m.TruePeak > loudnessTruePeak
+}
+
+// normalizeLoudness re-encodes a video's audio to target with loudnorm's
+// second pass, copying the video. The caller must remove the new file.
+func normalizeLoudness(ctx context.Context, media ffmpeg.MediaTool, path string, measured loudnessMeasurement, target float64) (string, error) {
+	outputFilePath := path + ".normalized.mp4"
+	args := []string{"-v", "error", "-y", "-i", path, "-map", "0:v:0", "-map", "0:a:0",
+		"-c:v", "copy", "-af", loudnormFilter(target, &measured), "-ar", loudnormSampleRate}
+	args = append(args, aacEncodeArgs...)
+	args = append(args, "-f", "mp4", outputFilePath)
+	_, err := media.Transcode(ctx, args...)
+	if err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
@@ -5,26 +5,58 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/edge"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	port             string
-	s3Client         *s3.Client
+	db                 database.Client
+	jwtKeys            *auth.KeyRing
+	platform           string
+	filepathRoot       string
+	assetsRoot         string
+	assetFiles         *assetServer
+	s3Bucket           string
+	s3Region           string
+	s3CfDistribution   string
+	port               string
+	s3Client           *s3.Client
+	videosRoot         string
+	videoFiles         *assetServer
+	blobLocks          *blobLocks
+	videoInputTypes    []string
+	media              ffmpeg.MediaTool
+	storyboardInterval time.Duration
+	previewLength      time.Duration
+	audioFormat        *audioFormat
+	loudnessTarget     float64
+	loudnessNormalize  bool
+	watermark          *watermark
+	mailer             mailer.Mailer
+	oidc               *oidc.Client
+	edge               *edge.Cache
+	cdnInvalidator     cdn.Invalidator
+	cdnWake            chan struct{}
+	transcriber        transcribe.Transcriber
+	transcribeLanguage string
+	transcribeWake     chan struct{}
+	publicURL          string
 }
 
 // type thumbnail struct {
@@ -40,15 +72,6 @@ var aspectRatioToPrefix = map[string]string{
 	"other": "other/",
 }
 
-type FFProbeResult struct {
-	Streams []Stream `json:"streams"`
-}
-
-type Stream struct {
-	Width  int `json:"width"`
-	Height int `json:"height"`
-}
-
 func main() {
 	godotenv.Load(".env")
 
@@ -62,16 +85,22 @@ func main() {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	jwtKeysDir := os.Getenv("JWT_KEYS_DIR")
+	if jwtKeysDir == "" {
+		log.Fatal("JWT_KEYS_DIR environment variable is not set")
 	}
+	jwtActiveKID := os.Getenv("JWT_ACTIVE_KID")
 
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
 		log.Fatal("PLATFORM environment variable is not set")
 	}
 
+	jwtKeys, err := loadJWTKeys(jwtKeysDir, jwtActiveKID, platform)
+	if err != nil {
+		log.Fatalf("Couldn't load JWT signing keys: %v", err)
+	}
+
 	filepathRoot := os.Getenv("FILEPATH_ROOT")
 	if filepathRoot == "" {
 		log.Fatal("FILEPATH_ROOT environment variable is not set")
@@ -82,43 +111,212 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	// Without S3 the videos are kept on local disk and streamed by the API.
 	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	s3Region := os.Getenv("S3_REGION")
+	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+	var s3Client *s3.Client
+	var cloudFrontClient *cloudfront.Client
+	cloudFrontDistributionID := os.Getenv("CLOUDFRONT_DISTRIBUTION_ID")
+	var videosRoot string
+	var videoFiles *assetServer
+	if s3Bucket != "" {
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+		if s3CfDistribution == "" {
+			log.Fatal("S3_CF_DISTRO environment variable is not set")
+		}
+
+		awscfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatal("awsconfig error")
+		}
+		s3Client = s3.NewFromConfig(awscfg)
+		if cloudFrontDistributionID != "" {
+			cloudFrontClient = cloudfront.NewFromConfig(awscfg)
+		}
+	} else {
+		videosRoot = os.Getenv("VIDEOS_ROOT")
+		if videosRoot == "" {
+			videosRoot = "./videos"
+		}
+		log.Printf("S3_BUCKET is not set, storing videos in %s", videosRoot)
+		err = os.MkdirAll(videosRoot, 0755)
+		if err != nil {
+			log.Fatalf("Couldn't create videos directory: %v", err)
+		}
+		videoFiles = newAssetServer(videosRoot)
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	videoInputTypes := defaultVideoInputTypes
+	if videoInputTypesString := os.Getenv("VIDEO_INPUT_TYPES"); videoInputTypesString != "" {
+		videoInputTypes, err = parseVideoInputTypes(videoInputTypesString)
+		if err != nil {
+			log.Fatalf("Invalid VIDEO_INPUT_TYPES: %v", err)
+		}
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	storyboardSeconds := defaultStoryboardSeconds
+	if storyboardString := os.Getenv("STORYBOARD_INTERVAL"); storyboardString != "" {
+		storyboardSeconds, err = strconv.Atoi(storyboardString)
+		if err != nil || storyboardSeconds < 0 {
+			log.Fatalf("Invalid STORYBOARD_INTERVAL %q", storyboardString)
+		}
+	}
+
+	previewSeconds := defaultPreviewSeconds
+	if previewString := os.Getenv("PREVIEW_SECONDS"); previewString != "" {
+		previewSeconds, err = strconv.Atoi(previewString)
+		if err != nil || previewSeconds < 0 {
+			log.Fatalf("Invalid PREVIEW_SECONDS %q", previewString)
+		}
 	}
 
-	awscfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+	audio, err := parseAudioFormat(os.Getenv("AUDIO_FORMAT"))
 	if err != nil {
-		log.Fatal("awsconfig error")
+		log.Fatalf("Invalid AUDIO_FORMAT: %v", err)
+	}
+
+	loudnessTarget, err := parseLoudnessTarget(os.Getenv("LOUDNESS_TARGET"))
+	if err != nil {
+		log.Fatalf("Invalid LOUDNESS_TARGET: %v", err)
+	}
+	loudnessNormalize := false
+	if normalizeString := os.Getenv("LOUDNESS_NORMALIZE"); normalizeString != "" {
+		loudnessNormalize, err = strconv.ParseBool(normalizeString)
+		if err != nil {
+			log.Fatalf("Invalid LOUDNESS_NORMALIZE %q", normalizeString)
+		}
+	}
+
+	wm, err := watermarkFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid watermark: %v", err)
+	}
+
+	mediaTool, err := mediaToolFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid media tool settings: %v", err)
+	}
+
+	transcriber, err := newTranscriber(os.Getenv("TRANSCRIBER"))
+	if err != nil {
+		log.Fatalf("Couldn't set up transcriber: %v", err)
+	}
+	transcribeLanguage := transcribe.AutoDetect
+	if language := os.Getenv("TRANSCRIBE_LANGUAGE"); language != "" && language != transcribe.AutoDetect {
+		var ok bool
+		transcribeLanguage, ok = canonicalLanguage(language)
+		if !ok {
+			log.Fatalf("Invalid TRANSCRIBE_LANGUAGE %q", language)
+		}
 	}
-	s3Client := s3.NewFromConfig(awscfg)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Tubely <no-reply@localhost>"
+	}
+	var mail mailer.Mailer
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		mail = mailer.SMTPMailer{
+			Addr:     smtpAddr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom,
+		}
+	} else {
+		outboxDir := os.Getenv("MAIL_OUTBOX_DIR")
+		if outboxDir == "" {
+			outboxDir = "./outbox"
+		}
+		log.Printf("SMTP_ADDR is not set, writing outgoing email to %s", outboxDir)
+		mail = mailer.FileMailer{Dir: outboxDir, From: mailFrom}
+	}
+
+	var oidcClient *oidc.Client
+	if oidcIssuer := os.Getenv("OIDC_ISSUER"); oidcIssuer != "" {
+		oidcClientID := os.Getenv("OIDC_CLIENT_ID")
+		if oidcClientID == "" {
+			log.Fatal("OIDC_CLIENT_ID environment variable is not set")
+		}
+		oidcRedirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if oidcRedirectURL == "" {
+			oidcRedirectURL = strings.TrimSuffix(publicURL, "/") + "/api/oidc/callback"
+		}
+		oidcClient = oidc.NewClient(oidc.Config{
+			Issuer:       oidcIssuer,
+			ClientID:     oidcClientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  oidcRedirectURL,
+			Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		})
+	}
+
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+		db:                 db,
+		jwtKeys:            jwtKeys,
+		platform:           platform,
+		filepathRoot:       filepathRoot,
+		assetsRoot:         assetsRoot,
+		assetFiles:         newAssetServer(assetsRoot),
+		s3Bucket:           s3Bucket,
+		s3Region:           s3Region,
+		s3CfDistribution:   s3CfDistribution,
+		port:               port,
+		s3Client:           s3Client,
+		videosRoot:         videosRoot,
+		videoFiles:         videoFiles,
+		blobLocks:          newBlobLocks(),
+		videoInputTypes:    videoInputTypes,
+		media:              mediaTool,
+		storyboardInterval: time.Duration(storyboardSeconds) * time.Second,
+		previewLength:      time.Duration(previewSeconds) * time.Second,
+		audioFormat:        audio,
+		loudnessTarget:     loudnessTarget,
+		loudnessNormalize:  loudnessNormalize,
+		watermark:          wm,
+		mailer:             mail,
+		oidc:               oidcClient,
+		publicURL:          strings.TrimSuffix(publicURL, "/"),
+		cdnWake:            make(chan struct{}, 1),
+		transcriber:        transcriber,
+		transcribeLanguage: transcribeLanguage,
+		transcribeWake:     make(chan struct{}, 1),
+	}
+
+	if edgeCacheDir := os.Getenv("EDGE_CACHE_DIR"); edgeCacheDir != "" {
+		maxMB := int64(1024)
+		if maxMBString := os.Getenv("EDGE_CACHE_MAX_MB"); maxMBString != "" {
+			maxMB, err = strconv.ParseInt(maxMBString, 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid EDGE_CACHE_MAX_MB: %v", err)
+			}
+		}
+		cfg.edge, err = cfg.newEdgeCache(edgeCacheDir, os.Getenv("EDGE_ORIGIN"), maxMB<<20)
+		if err != nil {
+			log.Fatalf("Couldn't set up edge cache: %v", err)
+		}
+		log.Printf("Edge cache enabled, serving %s/edge/ from %s", cfg.publicURL, edgeCacheDir)
+	}
+
+	switch {
+	case cfg.edge != nil:
+		cfg.cdnInvalidator = cdn.Edge{Cache: cfg.edge}
+	case cloudFrontClient != nil:
+		cfg.cdnInvalidator = cdn.CloudFront{Client: cloudFrontClient, DistributionID: cloudFrontDistributionID}
+	default:
+		cfg.cdnInvalidator = cdn.Noop{}
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -130,30 +328,82 @@ func main() {
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	assetsHandler := http.StripPrefix("/assets", cfg.assetFiles)
+	mux.Handle("/assets/", assetsHandler)
+	if cfg.edge != nil {
+		mux.Handle("/edge/", http.StripPrefix("/edge", cfg.edge))
+	}
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/totp", cfg.handlerLoginTOTP)
+	mux.HandleFunc("GET /api/oidc/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/callback", cfg.handlerOIDCCallback)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", cfg.handlerSessionsList)
+	mux.HandleFunc("DELETE /api/sessions", cfg.handlerSessionsRevokeAll)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.handlerSessionRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/email_verification", cfg.handlerEmailVerificationRequest)
+	mux.HandleFunc("POST /api/email_verification/confirm", cfg.handlerEmailVerificationConfirm)
+	mux.HandleFunc("POST /api/password_reset", cfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/password_reset/confirm", cfg.handlerPasswordResetConfirm)
+	mux.HandleFunc("GET /api/users/{userID}/podcast.xml", cfg.handlerPodcastFeed)
+	mux.HandleFunc("POST /api/totp/enroll", cfg.handlerTOTPEnroll)
+	mux.HandleFunc("POST /api/totp/enable", cfg.handlerTOTPEnable)
+	mux.HandleFunc("POST /api/totp/disable", cfg.handlerTOTPDisable)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream/{file}", cfg.handlerVideoStream)
 	// mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/collaborators", cfg.handlerVideoCollaboratorsList)
+	mux.HandleFunc("POST /api/videos/{videoID}/collaborators", cfg.handlerVideoCollaboratorsAdd)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/collaborators/{userID}", cfg.handlerVideoCollaboratorsRemove)
+	mux.HandleFunc("GET /api/videos/{videoID}/captions", cfg.handlerVideoCaptionsList)
+	mux.HandleFunc("PUT /api/videos/{videoID}/captions/{language}", cfg.handlerVideoCaptionsUpload)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/captions/{language}", cfg.handlerVideoCaptionsDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/chapters", cfg.handlerVideoChaptersList)
+	mux.HandleFunc("GET /api/videos/{videoID}/chapters.vtt", cfg.handlerVideoChaptersVTT)
+	mux.HandleFunc("PUT /api/videos/{videoID}/chapters", cfg.handlerVideoChaptersSet)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/chapters", cfg.handlerVideoChaptersDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/edit", cfg.handlerVideoEditGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/edit", cfg.handlerVideoEdit)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/edit", cfg.handlerVideoEditUndo)
+	mux.HandleFunc("POST /api/videos/{videoID}/watermark/preview", cfg.handlerWatermarkPreview)
+	mux.HandleFunc("GET /api/videos/{videoID}/original", cfg.handlerVideoOriginal)
+	mux.HandleFunc("GET /api/watermark", cfg.handlerWatermarkGet)
+	mux.HandleFunc("PUT /api/watermark", cfg.handlerWatermarkSet)
+	mux.HandleFunc("DELETE /api/watermark", cfg.handlerWatermarkDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/transcriptions", cfg.handlerVideoTranscriptionsList)
+	mux.HandleFunc("POST /api/videos/{videoID}/transcriptions", cfg.handlerVideoTranscriptionsCreate)
+
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/login_attempts", cfg.handlerLoginAttemptsGet)
+	mux.HandleFunc("POST /admin/edge/purge", cfg.handlerEdgePurge)
+	mux.HandleFunc("GET /admin/cdn/invalidations", cfg.handlerCDNInvalidationsGet)
+	mux.HandleFunc("POST /admin/cdn/invalidations/{invalidationID}/retry", cfg.handlerCDNInvalidationRetry)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: mux,
 	}
 
+	go reloadJWTKeysOnHangup(jwtKeys, jwtActiveKID)
+	go cfg.runCleanup()
+	go cfg.runCDNInvalidations()
+	if cfg.transcriber != nil {
+		go cfg.runTranscriptions()
+	}
+
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
+)
+
+// newTestConfig returns a config backed by a fresh database and temporary
+// directories, storing videos locally and processing media with a fake.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	jwtKeys, err := loadJWTKeys(filepath.Join(dir, "keys"), "", "dev")
+	if err != nil {
+		t.Fatalf("loadJWTKeys: %v", err)
+	}
+	assetsRoot := filepath.Join(dir, "assets")
+	videosRoot := filepath.Join(dir, "videos")
+	for _, root := range []string{assetsRoot, videosRoot} {
+		err = os.MkdirAll(root, 0755)
+		if err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+	}
+
+	return &apiConfig{
+		db:                 db,
+		jwtKeys:            jwtKeys,
+		platform:           "dev",
+		assetsRoot:         assetsRoot,
+		assetFiles:         newAssetServer(assetsRoot),
+		videosRoot:         videosRoot,
+		videoFiles:         newAssetServer(videosRoot),
+		blobLocks:          newBlobLocks(),
+		videoInputTypes:    defaultVideoInputTypes,
+		media:              &ffmpeg.Fake{},
+		loudnessTarget:     defaultLoudnessTarget,
+		publicURL:          "http://tubely.test",
+		cdnWake:            make(chan struct{}, 1),
+		transcribeLanguage: transcribe.AutoDetect,
+		transcribeWake:     make(chan struct{}, 1),
+	}
+}
+
+// newTestUser creates a user and signs them in, returning the user and an
+// access token.
+func newTestUser(t *testing.T, cfg *apiConfig, email string) (database.User, string) {
+	t.Helper()
+	hashedPassword, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: hashedPassword})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	accessToken, _, err := cfg.createSession(httptest.NewRequest("POST", "/api/login", nil), *user)
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	return *user, accessToken
+}
+
+// newTestVideo creates a video owned by user.
+func newTestVideo(t *testing.T, cfg *apiConfig, user database.User) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:       "Test video",
+		Description: "A video for tests",
+		UserID:      user.ID,
+	})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	return video
+}
+
+// writeTestFile writes contents to a new file in a temporary directory
+// and returns its path.
+func writeTestFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+// listFiles returns the paths of the regular files under root, relative
+// to it.
+func listFiles(t *testing.T, root string) []string {
+	t.Helper()
+	files := []string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	return files
+}
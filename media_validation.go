@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+// Error codes returned when an upload isn't acceptable media.
+const (
+	errCodeUnsupportedMediaType = "unsupported_media_type"
+	errCodeContentTypeMismatch  = "content_type_mismatch"
+	errCodeInvalidMedia         = "invalid_media"
+	errCodeMediaTooLong         = "media_too_long"
+	errCodeResolutionTooHigh    = "resolution_too_high"
+	errCodeBitrateTooHigh       = "bitrate_too_high"
+)
+
+const (
+	maxImageDimension = 4096
+	maxVideoDimension = 4096
+	maxVideoDuration  = 4 * 60 * 60 // seconds
+	maxVideoBitrate   = 50_000_000  // bits per second
+)
+
+var thumbnailMediaTypes = []string{"image/jpeg", "image/png"}
+
+// videoContainerExtensions lists the video containers uploads may come in,
+// with the extension their original is stored under.
+var videoContainerExtensions = map[string]string{
+	"video/mp4":        ".mp4",
+	"video/quicktime":  ".mov",
+	"video/webm":       ".webm",
+	"video/x-matroska": ".mkv",
+}
+
+// defaultVideoInputTypes is every container we know how to convert.
+var defaultVideoInputTypes = []string{"video/mp4", "video/quicktime", "video/webm", "video/x-matroska"}
+
+// mediaTypeAliases maps other names clients use for a media type to the
+// one we sniff.
+var mediaTypeAliases = map[string]string{
+	"video/x-m4v":    "video/mp4",
+	"video/matroska": "video/x-matroska",
+}
+
+// parseVideoInputTypes parses a comma-separated list of video media types
+// to accept, such as "video/mp4,video/quicktime".
+func parseVideoInputTypes(s string) ([]string, error) {
+	types := []string{}
+	for _, mediaType := range strings.Split(s, ",") {
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if alias, ok := mediaTypeAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		if mediaType == "" {
+			continue
+		}
+		if _, ok := videoContainerExtensions[mediaType]; !ok {
+			return nil, fmt.Errorf("unsupported video type %q", mediaType)
+		}
+		types = append(types, mediaType)
+	}
+	if len(types) == 0 {
+		return nil, errors.New("no video types given")
+	}
+	return types, nil
+}
+
+// mediaError is an upload rejected for what it contains, with the status
+// and error code to report it with.
+type mediaError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *mediaError) Error() string {
+	return e.Message
+}
+
+func invalidMedia(code, format string, args ...any) error {
+	return &mediaError{Status: http.StatusBadRequest, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// respondWithMediaError reports a failed media check, falling back to a
+// 500 for errors that aren't the upload's fault.
+func respondWithMediaError(w http.ResponseWriter, err error) {
+	var mediaErr *mediaError
+	if errors.As(err, &mediaErr) {
+		respondWithErrorCode(w, mediaErr.Status, mediaErr.Code, mediaErr.Message, nil)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't inspect uploaded file", err)
+}
+
+// sniffMediaType detects a file's type from its first bytes, ignoring
+// whatever the client claimed.
+func sniffMediaType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if mediaType := sniffVideoContainer(buf[:n]); mediaType != "" {
+		return mediaType, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+// checkMediaType decides an upload's media type from its contents. The
+// declared Content-Type must agree with them unless it is missing or
+// generic.
+func checkMediaType(declared string, path string, allowed []string) (string, error) {
+	declaredType := ""
+	if declared != "" {
+		parsed, _, err := mime.ParseMediaType(declared)
+		if err != nil {
+			return "", invalidMedia(errCodeUnsupportedMediaType, "Invalid Content-Type %q", declared)
+		}
+		if alias, ok := mediaTypeAliases[parsed]; ok {
+			parsed = alias
+		}
+		if parsed != "application/octet-stream" {
+			declaredType = parsed
+		}
+	}
+	if declaredType != "" && !isAllowedMediaType(declaredType, allowed) {
+		return "", &mediaError{
+			Status:  http.StatusUnsupportedMediaType,
+			Code:    errCodeUnsupportedMediaType,
+			Message: fmt.Sprintf("Unsupported media type %s, expected one of %s", declaredType, strings.Join(allowed, ", ")),
+		}
+	}
+
+	sniffed, err := sniffMediaType(path)
+	if err != nil {
+		return "", err
+	}
+	if !isAllowedMediaType(sniffed, allowed) {
+		return "", &mediaError{
+			Status:  http.StatusUnsupportedMediaType,
+			Code:    errCodeUnsupportedMediaType,
+			Message: fmt.Sprintf("File contents look like %s, expected one of %s", sniffed, strings.Join(allowed, ", ")),
+		}
+	}
+	// WebM is a restricted Matroska, so a .webm sent as Matroska is fine.
+	if declaredType != "" && declaredType != sniffed && !(declaredType == "video/x-matroska" && sniffed == "video/webm") {
+		return "", invalidMedia(errCodeContentTypeMismatch, "File was sent as %s but its contents are %s", declaredType, sniffed)
+	}
+	return sniffed, nil
+}
+
+// sniffVideoContainer recognizes the containers http.DetectContentType
+// can't tell apart: it calls every EBML file WebM and misses QuickTime.
+func sniffVideoContainer(buf []byte) string {
+	if bytes.HasPrefix(buf, []byte("\x1A\x45\xDF\xA3")) {
+		// The EBML header's DocType element names the flavour.
+		if bytes.Contains(buf, []byte("webm")) {
+			return "video/webm"
+		}
+		if bytes.Contains(buf, []byte("matroska")) {
+			return "video/x-matroska"
+		}
+		return ""
+	}
+	if len(buf) < 12 {
+		return ""
+	}
+	switch string(buf[4:8]) {
+	case "ftyp":
+		boxSize := min(int(binary.BigEndian.Uint32(buf[:4])), len(buf))
+		if string(buf[8:12]) == "qt  " {
+			return "video/quicktime"
+		}
+		// Major brand, then compatible brands after the minor version.
+		brands := [][]byte{buf[8:12]}
+		for i := 16; i+4 <= boxSize; i += 4 {
+			brands = append(brands, buf[i:i+4])
+		}
+		for _, brand := range brands {
+			switch string(brand) {
+			case "isom", "iso2", "iso4", "iso5", "iso6", "mp41", "mp42", "avc1", "M4V ", "dash":
+				return "video/mp4"
+			}
+		}
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		// QuickTime files from before ftyp existed start with a bare atom.
+		return "video/quicktime"
+	}
+	return ""
+}
+
+func isAllowedMediaType(mediaType string, allowed []string) bool {
+	for _, a := range allowed {
+		if mediaType == a {
+			return true
+		}
+	}
+	return false
+}
+
+// validateImage checks that an image decodes and isn't unreasonably large.
+func validateImage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return invalidMedia(errCodeInvalidMedia, "Image can't be decoded: %v", err)
+	}
+	if config.Width > maxImageDimension || config.Height > maxImageDimension {
+		return invalidMedia(errCodeResolutionTooHigh, "Image is %dx%d, the limit is %dx%d",
+			config.Width, config.Height, maxImageDimension, maxImageDimension)
+	}
+
+	// The header alone doesn't prove the pixel data is intact.
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, _, err = image.Decode(f)
+	if err != nil {
+		return invalidMedia(errCodeInvalidMedia, "Image can't be decoded: %v", err)
+	}
+	return nil
+}
+
+// probeVideo runs ffprobe on a file. Files ffprobe can't parse are
+// reported as invalid media.
+func probeVideo(ctx context.Context, media ffmpeg.MediaTool, path string) (ffmpeg.ProbeResult, error) {
+	result, err := media.Probe(ctx, path)
+	var toolErr *ffmpeg.Error
+	if errors.As(err, &toolErr) && toolErr.Rejected() {
+		return ffmpeg.ProbeResult{}, invalidMedia(errCodeInvalidMedia, "Video can't be read: %s", toolErr.Summary())
+	}
+	if err != nil {
+		return ffmpeg.ProbeResult{}, err
+	}
+	return result, nil
+}
+
+// probedVideo is what validateVideo found in an upload.
+type probedVideo struct {
+	Video ffmpeg.Stream
+	// Audio is nil for silent videos.
+	Audio    *ffmpeg.Stream
+	Format   ffmpeg.Format
+	Duration time.Duration
+}
+
+// validateVideo checks that a video has a decodable video stream within
+// the duration, resolution and bitrate limits.
+func validateVideo(ctx context.Context, media ffmpeg.MediaTool, path string) (probedVideo, error) {
+	probe, err := probeVideo(ctx, media, path)
+	if err != nil {
+		return probedVideo{}, err
+	}
+
+	var video, audio *ffmpeg.Stream
+	for i := range probe.Streams {
+		switch probe.Streams[i].CodecType {
+		case "video":
+			if video == nil {
+				video = &probe.Streams[i]
+			}
+		case "audio":
+			if audio == nil {
+				audio = &probe.Streams[i]
+			}
+		}
+	}
+	if video == nil || video.Width <= 0 || video.Height <= 0 {
+		return probedVideo{}, invalidMedia(errCodeInvalidMedia, "File has no video stream")
+	}
+	if video.Width > maxVideoDimension || video.Height > maxVideoDimension {
+		return probedVideo{}, invalidMedia(errCodeResolutionTooHigh, "Video is %dx%d, the limit is %dx%d",
+			video.Width, video.Height, maxVideoDimension, maxVideoDimension)
+	}
+
+	duration, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil || duration <= 0 {
+		return probedVideo{}, invalidMedia(errCodeInvalidMedia, "Video has no duration")
+	}
+	if duration > maxVideoDuration {
+		return probedVideo{}, invalidMedia(errCodeMediaTooLong, "Video is %.0f seconds long, the limit is %d", duration, maxVideoDuration)
+	}
+
+	if bitrate, err := strconv.ParseInt(probe.Format.BitRate, 10, 64); err == nil && bitrate > maxVideoBitrate {
+		return probedVideo{}, invalidMedia(errCodeBitrateTooHigh, "Video bitrate is %d bit/s, the limit is %d", bitrate, maxVideoBitrate)
+	}
+
+	err = decodeFirstFrame(ctx, media, path)
+	if err != nil {
+		return probedVideo{}, err
+	}
+	return probedVideo{Video: *video, Audio: audio, Format: probe.Format, Duration: time.Duration(duration * float64(time.Second))}, nil
+}
+
+// decodeFirstFrame makes sure the video stream really decodes; a valid
+// container can still hold garbage.
+func decodeFirstFrame(ctx context.Context, media ffmpeg.MediaTool, path string) error {
+	f, err := os.CreateTemp("", "tubely-frame*.jpg")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	err = media.ExtractFrame(ctx, path, 0, f.Name())
+	var toolErr *ffmpeg.Error
+	if errors.As(err, &toolErr) && toolErr.Rejected() {
+		return invalidMedia(errCodeInvalidMedia, "Video can't be decoded: %s", toolErr.Summary())
+	}
+	return err
+}
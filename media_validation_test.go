@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+var (
+	testH264Stream = ffmpeg.Stream{CodecType: "video", CodecName: "h264", Width: 1920, Height: 1080, PixFmt: "yuv420p"}
+	testAACStream  = ffmpeg.Stream{CodecType: "audio", CodecName: "aac"}
+)
+
+func TestValidateVideo(t *testing.T) {
+	media := &ffmpeg.Fake{ProbeResult: ffmpeg.ProbeResult{
+		Streams: []ffmpeg.Stream{testAACStream, testH264Stream},
+		Format:  ffmpeg.Format{FormatName: "mov,mp4", Duration: "12.500", BitRate: "4000000"},
+	}}
+
+	probe, err := validateVideo(context.Background(), media, "upload.mp4")
+	if err != nil {
+		t.Fatalf("validateVideo: %v", err)
+	}
+	if probe.Video != testH264Stream || probe.Audio == nil || *probe.Audio != testAACStream {
+		t.Errorf("streams = %+v and %+v, want the H.264 and AAC streams", probe.Video, probe.Audio)
+	}
+	if probe.Duration != 12500*time.Millisecond {
+		t.Errorf("duration = %s, want 12.5s", probe.Duration)
+	}
+
+	calls := media.Calls()
+	if len(calls) != 2 || calls[0].Method != "Probe" || calls[1].Method != "ExtractFrame" || calls[1].Args[0] != "upload.mp4" {
+		t.Errorf("calls = %+v, want a probe and a first frame decoded", calls)
+	}
+}
+
+func TestValidateVideoRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		media    *ffmpeg.Fake
+		wantCode string
+	}{
+		{
+			name: "no video stream",
+			media: &ffmpeg.Fake{ProbeResult: ffmpeg.ProbeResult{
+				Streams: []ffmpeg.Stream{testAACStream},
+				Format:  ffmpeg.Format{Duration: "10"},
+			}},
+			wantCode: errCodeInvalidMedia,
+		},
+		{
+			name: "no duration",
+			media: &ffmpeg.Fake{ProbeResult: ffmpeg.ProbeResult{
+				Streams: []ffmpeg.Stream{testH264Stream},
+				Format:  ffmpeg.Format{Duration: "N/A"},
+			}},
+			wantCode: errCodeInvalidMedia,
+		},
+		{
+			name: "too large",
+			media: &ffmpeg.Fake{ProbeResult: ffmpeg.ProbeResult{
+				Streams: []ffmpeg.Stream{{CodecType: "video", CodecName: "h264", Width: 7680, Height: 4320}},
+				Format:  ffmpeg.Format{Duration: "10"},
+			}},
+			wantCode: errCodeResolutionTooHigh,
+		},
+		{
+			name: "too long",
+			media: &ffmpeg.Fake{ProbeResult: ffmpeg.ProbeResult{
+				Streams: []ffmpeg.Stream{testH264Stream},
+				Format:  ffmpeg.Format{Duration: "14401"},
+			}},
+			wantCode: errCodeMediaTooLong,
+		},
+		{
+			name: "bitrate too high",
+			media: &ffmpeg.Fake{ProbeResult: ffmpeg.ProbeResult{
+				Streams: []ffmpeg.Stream{testH264Stream},
+				Format:  ffmpeg.Format{Duration: "10", BitRate: "80000000"},
+			}},
+			wantCode: errCodeBitrateTooHigh,
+		},
+		{
+			name:     "unreadable",
+			media:    &ffmpeg.Fake{Err: &ffmpeg.Error{Tool: "ffprobe", ExitCode: 1, Stderr: "moov atom not found", Err: errors.New("exit status 1")}},
+			wantCode: errCodeInvalidMedia,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateVideo(context.Background(), tt.media, "upload.mp4")
+			var mediaErr *mediaError
+			if !errors.As(err, &mediaErr) || mediaErr.Code != tt.wantCode {
+				t.Fatalf("err = %v, want a %s media error", err, tt.wantCode)
+			}
+			for _, call := range tt.media.Calls() {
+				if call.Method == "ExtractFrame" && tt.media.Err == nil {
+					t.Errorf("rejected video was decoded anyway")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+// Preview clips are a few short muted segments sampled across the video,
+// small enough to autoplay on every card in a list.
+const (
+	previewSegments       = 4
+	previewWidth          = 320
+	previewFPS            = 12
+	defaultPreviewSeconds = 4
+)
+
+// preview is a stored animated preview in both formats.
+type preview struct {
+	WebP    database.Blob
+	WebPURL string
+	MP4     database.Blob
+	MP4URL  string
+}
+
+// previewSegmentStarts spreads segments of segmentLength evenly over a
+// video, skipping the very start and end, which are often titles or black.
+// Videos too short to sample are used whole: one segment from the start.
+func previewSegmentStarts(duration, segmentLength time.Duration) []time.Duration {
+	if duration <= segmentLength*previewSegments*2 {
+		return []time.Duration{0}
+	}
+	starts := make([]time.Duration, 0, previewSegments)
+	for i := range previewSegments {
+		// Segment i starts at (2i+1)/(2n) of the way through.
+		starts = append(starts, duration*time.Duration(2*i+1)/(2*previewSegments)-segmentLength/2)
+	}
+	return starts
+}
+
+// renderPreviewMP4 cuts the sampled segments out of a video and joins them
+// into a small silent H.264 MP4.
+func renderPreviewMP4(ctx context.Context, media ffmpeg.MediaTool, videoPath, outputPath string, duration, length time.Duration) error {
+	segmentLength := length / previewSegments
+	starts := previewSegmentStarts(duration, segmentLength)
+	if len(starts) == 1 {
+		segmentLength = min(length, duration)
+	}
+
+	args := []string{"-v", "error", "-y"}
+	filters := []string{}
+	labels := ""
+	for i, start := range starts {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", start.Seconds()), "-t", fmt.Sprintf("%.3f", segmentLength.Seconds()), "-i", videoPath)
+		filters = append(filters, fmt.Sprintf("[%d:v]scale=%d:-2,fps=%d,setsar=1[v%d]", i, previewWidth, previewFPS, i))
+		labels += fmt.Sprintf("[v%d]", i)
+	}
+	filters = append(filters, fmt.Sprintf("%sconcat=n=%d:v=1:a=0[v]", labels, len(starts)))
+	args = append(args,
+		"-filter_complex", strings.Join(filters, ";"), "-map", "[v]", "-an",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "30", "-pix_fmt", "yuv420p",
+		"-movflags", "+faststart", "-f", "mp4", outputPath)
+	_, err := media.Transcode(ctx, args...)
+	return err
+}
+
+// renderPreviewWebP converts a preview MP4 to a looping animated WebP,
+// which plays anywhere an image can go.
+func renderPreviewWebP(ctx context.Context, media ffmpeg.MediaTool, mp4Path, outputPath string) error {
+	_, err := media.Transcode(ctx, "-v", "error", "-y", "-i", mp4Path,
+		"-c:v", "libwebp", "-loop", "0", "-q:v", "60", "-an", "-f", "webp", outputPath)
+	return err
+}
+
+// makePreview renders a video's animated preview and stores both formats
+// with the video files, so private videos' previews need the same access
+// as their video.
+func (cfg *apiConfig) makePreview(ctx context.Context, videoID uuid.UUID, videoPath string, probe probedVideo) (preview, error) {
+	dir, err := os.MkdirTemp("", "tubely-preview")
+	if err != nil {
+		return preview{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	mp4Path := filepath.Join(dir, "preview.mp4")
+	err = renderPreviewMP4(ctx, cfg.media, videoPath, mp4Path, probe.Duration, cfg.previewLength)
+	if err != nil {
+		return preview{}, err
+	}
+	webpPath := filepath.Join(dir, "preview.webp")
+	err = renderPreviewWebP(ctx, cfg.media, mp4Path, webpPath)
+	if err != nil {
+		return preview{}, err
+	}
+
+	mp4File, err := hashFile(mp4Path)
+	if err != nil {
+		return preview{}, err
+	}
+	webpFile, err := hashFile(webpPath)
+	if err != nil {
+		return preview{}, err
+	}
+
+	clip := preview{}
+	clip.MP4, clip.MP4URL, err = cfg.storeVideoBlob(ctx, videoID, mp4File, "preview/", ".mp4", "video/mp4")
+	if err != nil {
+		return preview{}, err
+	}
+	clip.WebP, clip.WebPURL, err = cfg.storeVideoBlob(ctx, videoID, webpFile, "preview/", ".webp", "image/webp")
+	if err != nil {
+		cfg.releaseBlob(clip.MP4.Storage, &clip.MP4.SHA256)
+		return preview{}, err
+	}
+	return clip, nil
+}
+
+// releaseVideoPreview drops the references a video's saved preview holds.
+func (cfg *apiConfig) releaseVideoPreview(video database.Video) {
+	storage := cfg.renditionStorage(video.PreviewURL)
+	cfg.releaseBlob(storage, video.PreviewSHA256)
+	cfg.releaseBlob(storage, video.PreviewMP4SHA256)
+}
+
+// releasePreview drops the references to a preview's blobs.
+func (cfg *apiConfig) releasePreview(clip preview) {
+	if clip.WebP.SHA256 != "" {
+		cfg.releaseBlob(clip.WebP.Storage, &clip.WebP.SHA256)
+	}
+	if clip.MP4.SHA256 != "" {
+		cfg.releaseBlob(clip.MP4.Storage, &clip.MP4.SHA256)
+	}
+}
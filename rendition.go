@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoAssets are what's rendered from a published MP4 for players,
+// cards and listeners. Any may be missing: they are nice to have.
+type videoAssets struct {
+	Storyboard storyboard
+	Preview    preview
+	Audio      database.Blob
+	AudioURL   string
+}
+
+// makeVideoAssets renders the storyboard, preview and audio-only rendition
+// for a published MP4. Failures are logged and the asset left out, so
+// publishing goes ahead.
+func (cfg *apiConfig) makeVideoAssets(ctx context.Context, videoID uuid.UUID, videoPath string, probe probedVideo) videoAssets {
+	var assets videoAssets
+	var err error
+	if cfg.storyboardInterval > 0 {
+		assets.Storyboard, err = cfg.makeStoryboard(ctx, videoID, videoPath, probe)
+		if err != nil {
+			log.Printf("Couldn't make storyboard for video %s: %v", videoID, err)
+		}
+	}
+	if cfg.previewLength > 0 {
+		assets.Preview, err = cfg.makePreview(ctx, videoID, videoPath, probe)
+		if err != nil {
+			log.Printf("Couldn't make preview for video %s: %v", videoID, err)
+		}
+	}
+	if cfg.audioFormat != nil && probe.Audio != nil {
+		assets.Audio, assets.AudioURL, err = cfg.makeAudio(ctx, videoID, videoPath)
+		if err != nil {
+			log.Printf("Couldn't extract audio for video %s: %v", videoID, err)
+		}
+	}
+	return assets
+}
+
+// renditionStorage is where the storyboard or preview at u is stored. They
+// used to be public assets, and now go with the video files.
+func (cfg *apiConfig) renditionStorage(u *string) string {
+	if u != nil && strings.Contains(*u, "/assets/") {
+		return blobStorageAssets
+	}
+	return cfg.videoBlobStorage()
+}
+
+// dropPublicRenditions takes a video that is being made private off the
+// renditions older versions stored as public assets, which anyone could
+// still fetch. Its next upload or edit renders them again. It returns the
+// video as it was with the storyboard sheets it held, for
+// releasePublicRenditions once the video is saved.
+func (cfg *apiConfig) dropPublicRenditions(video *database.Video) (database.Video, []string, error) {
+	oldVideo := *video
+	var oldSheets []string
+	if video.StoryboardSHA256 != nil && cfg.renditionStorage(video.StoryboardURL) == blobStorageAssets {
+		var err error
+		oldSheets, err = cfg.db.GetVideoStoryboardSheets(video.ID)
+		if err != nil {
+			return database.Video{}, nil, err
+		}
+		video.StoryboardURL, video.StoryboardSHA256 = nil, nil
+	}
+	if video.PreviewSHA256 != nil && cfg.renditionStorage(video.PreviewURL) == blobStorageAssets {
+		video.PreviewURL, video.PreviewSHA256 = nil, nil
+		video.PreviewMP4URL, video.PreviewMP4SHA256 = nil, nil
+	}
+	return oldVideo, oldSheets, nil
+}
+
+// releasePublicRenditions drops the references to what
+// dropPublicRenditions took off a video.
+func (cfg *apiConfig) releasePublicRenditions(video, oldVideo database.Video, oldSheets []string) {
+	if video.StoryboardSHA256 == nil && oldVideo.StoryboardSHA256 != nil {
+		err := cfg.db.SetVideoStoryboardSheets(video.ID, nil)
+		if err != nil {
+			log.Printf("Couldn't clear storyboard sheets for video %s: %v", video.ID, err)
+		}
+		cfg.releaseVideoStoryboard(oldVideo, oldSheets)
+	}
+	if video.PreviewSHA256 == nil && oldVideo.PreviewSHA256 != nil {
+		cfg.releaseVideoPreview(oldVideo)
+	}
+}
+
+func (cfg *apiConfig) releaseVideoAssets(assets videoAssets) {
+	cfg.releaseStoryboard(assets.Storyboard)
+	cfg.releasePreview(assets.Preview)
+	cfg.releaseBlob(assets.Audio.Storage, &assets.Audio.SHA256)
+}
+
+// setVideoAssets points a video at new assets and its new duration. It
+// returns the storyboard sheets, which are saved apart from the video.
+func (cfg *apiConfig) setVideoAssets(video *database.Video, assets videoAssets, probe probedVideo) []string {
+	video.PreviewURL, video.PreviewSHA256 = nil, nil
+	video.PreviewMP4URL, video.PreviewMP4SHA256 = nil, nil
+	if clip := assets.Preview; clip.WebP.SHA256 != "" {
+		video.PreviewURL, video.PreviewSHA256 = &clip.WebPURL, &clip.WebP.SHA256
+		video.PreviewMP4URL, video.PreviewMP4SHA256 = &clip.MP4URL, &clip.MP4.SHA256
+	}
+	video.AudioURL, video.AudioSHA256 = nil, nil
+	if assets.Audio.SHA256 != "" {
+		video.AudioURL, video.AudioSHA256 = &assets.AudioURL, &assets.Audio.SHA256
+	}
+	durationMS := probe.Duration.Milliseconds()
+	video.DurationMS = &durationMS
+	video.StoryboardURL, video.StoryboardSHA256 = nil, nil
+	sheetSHA256s := []string{}
+	if board := assets.Storyboard; board.Index.SHA256 != "" {
+		video.StoryboardURL, video.StoryboardSHA256 = &board.IndexURL, &board.Index.SHA256
+		for _, sheet := range board.Sheets {
+			sheetSHA256s = append(sheetSHA256s, sheet.SHA256)
+		}
+	}
+	return sheetSHA256s
+}
+
+// finishVideoAssets runs once a video with new assets has been saved: it
+// records the storyboard sheets, drops the old video's assets and fits
+// the chapters to the new duration.
+func (cfg *apiConfig) finishVideoAssets(video, oldVideo database.Video, sheetSHA256s, oldSheetSHA256s []string) {
+	err := cfg.db.SetVideoStoryboardSheets(video.ID, sheetSHA256s)
+	if err != nil {
+		log.Printf("Couldn't save storyboard sheets for video %s: %v", video.ID, err)
+	}
+	cfg.releaseVideoStoryboard(oldVideo, oldSheetSHA256s)
+	cfg.releaseVideoPreview(oldVideo)
+	cfg.releaseBlob(cfg.videoBlobStorage(), oldVideo.AudioSHA256)
+	if oldVideo.AudioURL != nil && (video.AudioURL == nil || *oldVideo.AudioURL != *video.AudioURL) {
+		cfg.invalidateCDN("audio replaced for video "+video.ID.String(), oldVideo.AudioURL)
+	}
+	err = cfg.updateChapters(video)
+	if err != nil {
+		log.Printf("Couldn't update chapters for video %s: %v", video.ID, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+// Storyboard layout: sheets of storyboardColumns x storyboardRows tiles,
+// each storyboardTileWidth pixels wide.
+const (
+	storyboardTileWidth = 160
+	storyboardColumns   = 10
+	storyboardRows      = 10
+	// storyboardMaxTiles bounds the sheets made for long videos; the
+	// interval is stretched to fit.
+	storyboardMaxTiles       = 1000
+	defaultStoryboardSeconds = 10
+)
+
+// storyboard is a set of stored sprite sheets with the WebVTT index that
+// maps time ranges to tiles in them.
+type storyboard struct {
+	Index    database.Blob
+	IndexURL string
+	Sheets   []database.Blob
+}
+
+// storyboardInterval is how far apart tiles are for a video, at least
+// minInterval.
+func storyboardInterval(duration, minInterval time.Duration) time.Duration {
+	interval := minInterval
+	if perTile := duration / storyboardMaxTiles; perTile > interval {
+		interval = perTile.Round(time.Second)
+	}
+	return interval
+}
+
+// storyboardTileHeight keeps the video's aspect ratio, rounded to the even
+// height encoders need.
+func storyboardTileHeight(width, height int) int {
+	h := storyboardTileWidth * height / width
+	return max(h+h%2, 2)
+}
+
+// renderStoryboardSheets grabs a frame every interval and tiles the frames
+// into JPEG sprite sheets in dir, returning their paths in order.
+func renderStoryboardSheets(ctx context.Context, media ffmpeg.MediaTool, videoPath, dir string, interval time.Duration, tileHeight int) ([]string, error) {
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:%d,tile=%dx%d",
+		interval.Seconds(), storyboardTileWidth, tileHeight, storyboardColumns, storyboardRows)
+	_, err := media.Transcode(ctx, "-v", "error", "-y", "-i", videoPath,
+		"-an", "-vf", filter, "-q:v", "5", filepath.Join(dir, "sheet-%04d.jpg"))
+	if err != nil {
+		return nil, err
+	}
+
+	sheets, err := filepath.Glob(filepath.Join(dir, "sheet-*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("ffmpeg made no storyboard sheets")
+	}
+	sort.Strings(sheets)
+	return sheets, nil
+}
+
+// storyboardIndex writes the WebVTT index players use for scrub previews:
+// one cue per tile, pointing at the tile with a media fragment. Sheets are
+// stored next to the index, so sheetURLs are relative to it.
+func storyboardIndex(sheetURLs []string, duration, interval time.Duration, tileHeight int) []byte {
+	perSheet := storyboardColumns * storyboardRows
+	var out strings.Builder
+	out.WriteString("WEBVTT\n")
+	for tile := 0; tile < len(sheetURLs)*perSheet; tile++ {
+		start := time.Duration(tile) * interval
+		if start >= duration {
+			break
+		}
+		end := min(start+interval, duration)
+		n := tile % perSheet
+		fmt.Fprintf(&out, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			captions.FormatTimestamp(start), captions.FormatTimestamp(end), sheetURLs[tile/perSheet],
+			n%storyboardColumns*storyboardTileWidth, n/storyboardColumns*tileHeight, storyboardTileWidth, tileHeight)
+	}
+	return []byte(out.String())
+}
+
+// makeStoryboard renders a video's storyboard and stores the sheets and
+// index with the video files, so private videos' storyboards need the
+// same access as their video.
+func (cfg *apiConfig) makeStoryboard(ctx context.Context, videoID uuid.UUID, videoPath string, probe probedVideo) (storyboard, error) {
+	interval := storyboardInterval(probe.Duration, cfg.storyboardInterval)
+	tileHeight := storyboardTileHeight(probe.Video.Width, probe.Video.Height)
+
+	dir, err := os.MkdirTemp("", "tubely-storyboard")
+	if err != nil {
+		return storyboard{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	sheetPaths, err := renderStoryboardSheets(ctx, cfg.media, videoPath, dir, interval, tileHeight)
+	if err != nil {
+		return storyboard{}, err
+	}
+
+	board := storyboard{}
+	sheetURLs := make([]string, 0, len(sheetPaths))
+	for _, sheetPath := range sheetPaths {
+		sheet, err := hashFile(sheetPath)
+		if err != nil {
+			cfg.releaseStoryboard(board)
+			return storyboard{}, err
+		}
+		blob, _, err := cfg.storeVideoBlob(ctx, videoID, sheet, "storyboard/", ".jpg", "image/jpeg")
+		if err != nil {
+			cfg.releaseStoryboard(board)
+			return storyboard{}, err
+		}
+		board.Sheets = append(board.Sheets, blob)
+		sheetURLs = append(sheetURLs, path.Base(blob.Key))
+	}
+
+	index, err := hashToTempFile(bytes.NewReader(storyboardIndex(sheetURLs, probe.Duration, interval, tileHeight)), "tubely-storyboard.vtt")
+	if err != nil {
+		cfg.releaseStoryboard(board)
+		return storyboard{}, err
+	}
+	defer os.Remove(index.Path)
+	board.Index, board.IndexURL, err = cfg.storeVideoBlob(ctx, videoID, index, "storyboard/", ".vtt", "text/vtt")
+	if err != nil {
+		cfg.releaseStoryboard(board)
+		return storyboard{}, err
+	}
+	return board, nil
+}
+
+// releaseStoryboard drops the references to a storyboard's blobs.
+func (cfg *apiConfig) releaseStoryboard(board storyboard) {
+	if board.Index.SHA256 != "" {
+		cfg.releaseBlob(board.Index.Storage, &board.Index.SHA256)
+	}
+	for _, sheet := range board.Sheets {
+		cfg.releaseBlob(sheet.Storage, &sheet.SHA256)
+	}
+}
+
+// releaseVideoStoryboard drops the references a video's saved storyboard
+// holds. The index is a column on the video, the sheets have a table.
+func (cfg *apiConfig) releaseVideoStoryboard(video database.Video, sheetSHA256s []string) {
+	storage := cfg.renditionStorage(video.StoryboardURL)
+	cfg.releaseBlob(storage, video.StoryboardSHA256)
+	for _, sha := range sheetSHA256s {
+		cfg.releaseBlob(storage, &sha)
+	}
+}
+
+// tokenizeStoryboardIndex adds a stream token to the sheet URLs in a
+// storyboard index, as tokenizePlaylist does for playlists.
+func tokenizeStoryboardIndex(index, token string) string {
+	lines := strings.Split(index, "\n")
+	for i, line := range lines {
+		if sheetURL, fragment, ok := strings.Cut(line, "#xywh="); ok {
+			lines[i] = sheetURL + "?token=" + url.QueryEscape(token) + "#xywh=" + fragment
+		}
+	}
+	return strings.Join(lines, "\n")
+}
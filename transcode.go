@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+// Encoder settings for uploads whose streams browsers can't play as-is.
+var (
+	h264EncodeArgs = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p"}
+	aacEncodeArgs  = []string{"-c:a", "aac", "-b:a", "160k"}
+)
+
+// playableInMP4 reports whether a video stream can be copied into an MP4
+// that plays everywhere: 8-bit 4:2:0 H.264.
+func playableInMP4(video ffmpeg.Stream) bool {
+	return video.CodecName == "h264" && (video.PixFmt == "yuv420p" || video.PixFmt == "yuvj420p")
+}
+
+// convertToMP4 turns an upload into an H.264/AAC MP4, copying streams that
+// are already compatible and re-encoding the rest. Compatible MP4s are
+// returned untouched; otherwise the caller must remove the new file.
+func convertToMP4(ctx context.Context, media ffmpeg.MediaTool, path, mediaType string, probe probedVideo) (string, error) {
+	copyVideo := playableInMP4(probe.Video)
+	copyAudio := probe.Audio == nil || probe.Audio.CodecName == "aac"
+	if mediaType == "video/mp4" && copyVideo && copyAudio {
+		return path, nil
+	}
+
+	outputFilePath := path + ".converted.mp4"
+	args := []string{"-v", "error", "-y", "-i", path, "-map", "0:v:0", "-map", "0:a:0?"}
+	if copyVideo {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, h264EncodeArgs...)
+	}
+	if copyAudio {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, aacEncodeArgs...)
+	}
+	args = append(args, "-f", "mp4", outputFilePath)
+
+	_, err := media.Transcode(ctx, args...)
+	if err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
+
+// mediaToolFromEnv sets up ffmpeg and ffprobe from FFMPEG_PATH and
+// FFPROBE_PATH, limited by FFMPEG_TIMEOUT, a duration such as "2h" for
+// each run, FFMPEG_THREADS per run and FFMPEG_MAX_JOBS runs at once.
+func mediaToolFromEnv() (*ffmpeg.Exec, error) {
+	cfg := ffmpeg.ExecConfig{
+		FFmpeg:  os.Getenv("FFMPEG_PATH"),
+		FFprobe: os.Getenv("FFPROBE_PATH"),
+	}
+	var err error
+	if timeoutString := os.Getenv("FFMPEG_TIMEOUT"); timeoutString != "" {
+		cfg.Timeout, err = time.ParseDuration(timeoutString)
+		if err != nil || cfg.Timeout < 0 {
+			return nil, fmt.Errorf("FFMPEG_TIMEOUT must be a duration such as 2h, got %q", timeoutString)
+		}
+	}
+	if threadsString := os.Getenv("FFMPEG_THREADS"); threadsString != "" {
+		cfg.Threads, err = strconv.Atoi(threadsString)
+		if err != nil || cfg.Threads < 0 {
+			return nil, fmt.Errorf("FFMPEG_THREADS must be a whole number, got %q", threadsString)
+		}
+	}
+	if jobsString := os.Getenv("FFMPEG_MAX_JOBS"); jobsString != "" {
+		cfg.MaxConcurrent, err = strconv.Atoi(jobsString)
+		if err != nil || cfg.MaxConcurrent < 0 {
+			return nil, fmt.Errorf("FFMPEG_MAX_JOBS must be a whole number, got %q", jobsString)
+		}
+	}
+	return ffmpeg.NewExec(cfg), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+)
+
+func TestConvertToMP4(t *testing.T) {
+	vp9 := ffmpeg.Stream{CodecType: "video", CodecName: "vp9", Width: 1280, Height: 720, PixFmt: "yuv420p"}
+	opus := ffmpeg.Stream{CodecType: "audio", CodecName: "opus"}
+	tests := []struct {
+		name      string
+		mediaType string
+		probe     probedVideo
+		// wantArgs are the codec arguments, nil when the upload is kept
+		// as it is.
+		wantArgs []string
+	}{
+		{
+			name:      "compatible MP4",
+			mediaType: "video/mp4",
+			probe:     probedVideo{Video: testH264Stream, Audio: &testAACStream},
+		},
+		{
+			name:      "compatible silent MP4",
+			mediaType: "video/mp4",
+			probe:     probedVideo{Video: testH264Stream},
+		},
+		{
+			name:      "compatible streams in another container",
+			mediaType: "video/quicktime",
+			probe:     probedVideo{Video: testH264Stream, Audio: &testAACStream},
+			wantArgs:  []string{"-c:v", "copy", "-c:a", "copy"},
+		},
+		{
+			name:      "incompatible audio",
+			mediaType: "video/mp4",
+			probe:     probedVideo{Video: testH264Stream, Audio: &opus},
+			wantArgs:  append([]string{"-c:v", "copy"}, aacEncodeArgs...),
+		},
+		{
+			name:      "incompatible video",
+			mediaType: "video/webm",
+			probe:     probedVideo{Video: vp9, Audio: &opus},
+			wantArgs:  append(slices.Clone(h264EncodeArgs), aacEncodeArgs...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			media := &ffmpeg.Fake{}
+			path := writeTestFile(t, "upload", "upload")
+
+			converted, err := convertToMP4(context.Background(), media, path, tt.mediaType, tt.probe)
+			if err != nil {
+				t.Fatalf("convertToMP4: %v", err)
+			}
+
+			calls := media.Calls()
+			if tt.wantArgs == nil {
+				if converted != path || len(calls) != 0 {
+					t.Errorf("converted to %s with %+v, want the upload kept", converted, calls)
+				}
+				return
+			}
+			if converted == path {
+				t.Fatal("upload wasn't converted")
+			}
+			if _, err := os.Stat(converted); err != nil {
+				t.Errorf("converted file: %v", err)
+			}
+			if len(calls) != 1 || calls[0].Method != "Transcode" {
+				t.Fatalf("calls = %+v, want one transcode", calls)
+			}
+			args := strings.Join(calls[0].Args, " ")
+			if !strings.Contains(args, strings.Join(tt.wantArgs, " ")) {
+				t.Errorf("transcoded with %q, want %q", args, strings.Join(tt.wantArgs, " "))
+			}
+			if !strings.HasSuffix(args, "-f mp4 "+converted) {
+				t.Errorf("transcoded with %q, want an MP4 written to %s", args, converted)
+			}
+		})
+	}
+}
+
+func TestProcessVideoForFastStart(t *testing.T) {
+	media := &ffmpeg.Fake{}
+	path := writeTestFile(t, "video.mp4", "video")
+
+	processed, err := processVideoForFastStart(context.Background(), media, path)
+	if err != nil {
+		t.Fatalf("processVideoForFastStart: %v", err)
+	}
+	if _, err := os.Stat(processed); err != nil {
+		t.Errorf("processed file: %v", err)
+	}
+
+	calls := media.Calls()
+	if len(calls) != 1 || calls[0].Method != "Remux" {
+		t.Fatalf("calls = %+v, want one remux", calls)
+	}
+	if !slices.Equal(calls[0].Args, []string{path, processed}) {
+		t.Errorf("remuxed %v, want %s to %s", calls[0].Args, path, processed)
+	}
+	want := ffmpeg.RemuxOptions{Format: "mp4", FastStart: true}
+	if calls[0].RemuxOptions != want {
+		t.Errorf("remuxed with %+v, want %+v", calls[0].RemuxOptions, want)
+	}
+}
+
+func TestProcessVideoForFastStartCleansUp(t *testing.T) {
+	media := &ffmpeg.Fake{Err: &ffmpeg.Error{Tool: "ffmpeg", ExitCode: 1}}
+	path := writeTestFile(t, "video.mp4", "video")
+
+	_, err := processVideoForFastStart(context.Background(), media, path)
+	if err == nil {
+		t.Fatal("processVideoForFastStart succeeded with a failing remux")
+	}
+	if _, err := os.Stat(path + ".processing"); !os.IsNotExist(err) {
+		t.Errorf("partial output was left behind: %v", err)
+	}
+}
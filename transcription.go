@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
+	"github.com/google/uuid"
+)
+
+const (
+	// transcriptionTimeout bounds one attempt. CPU models run at a few
+	// times real time at best, and videos may be hours long.
+	transcriptionTimeout        = 4 * time.Hour
+	transcriptionPollInterval   = time.Minute
+	transcriptionMaxAttempts    = 3
+	transcriptionRetryBackoff   = 5 * time.Minute
+	undeterminedCaptionLanguage = "und"
+)
+
+// errNoVideoFile means a transcription job's video has no file to
+// transcribe, e.g. because it was deleted; retrying won't help.
+var errNoVideoFile = errors.New("video has no file")
+
+// requestTranscription queues automatic captions for a video's current
+// file, if a transcriber is configured. Failures are logged: captions are
+// an extra, so they mustn't fail the upload that asked for them.
+func (cfg *apiConfig) requestTranscription(videoID uuid.UUID) {
+	if cfg.transcriber == nil {
+		return
+	}
+	_, err := cfg.db.CreateTranscriptionJob(videoID, cfg.transcribeLanguage)
+	if err != nil {
+		log.Printf("Couldn't queue transcription for video %s: %v", videoID, err)
+		return
+	}
+	cfg.wakeTranscriptions()
+}
+
+// wakeTranscriptions tells the worker there is a job to run.
+func (cfg *apiConfig) wakeTranscriptions() {
+	select {
+	case cfg.transcribeWake <- struct{}{}:
+	default:
+	}
+}
+
+// runTranscriptions runs queued transcription jobs one at a time until
+// the process exits. Transcription keeps every core busy, so running jobs
+// side by side wouldn't finish them any sooner.
+func (cfg *apiConfig) runTranscriptions() {
+	err := cfg.db.RequeueRunningTranscriptionJobs()
+	if err != nil {
+		log.Printf("Couldn't requeue interrupted transcriptions: %v", err)
+	}
+
+	ticker := time.NewTicker(transcriptionPollInterval)
+	defer ticker.Stop()
+	for {
+		for cfg.runNextTranscription() {
+		}
+		select {
+		case <-cfg.transcribeWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextTranscription runs one due job and reports whether there was one.
+func (cfg *apiConfig) runNextTranscription() bool {
+	job, err := cfg.db.ClaimTranscriptionJob(time.Now().UTC())
+	if err != nil {
+		log.Printf("Couldn't load pending transcriptions: %v", err)
+		return false
+	}
+	if job.ID == uuid.Nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), transcriptionTimeout)
+	defer cancel()
+	captionLanguage, note, err := cfg.transcribeVideo(ctx, job)
+	if err != nil {
+		log.Printf("Couldn't transcribe video %s: %v", job.VideoID, err)
+		var next *time.Time
+		if job.Attempts+1 < transcriptionMaxAttempts && !errors.Is(err, errNoVideoFile) {
+			t := time.Now().UTC().Add(transcriptionRetryBackoff << job.Attempts)
+			next = &t
+		}
+		err = cfg.db.RecordTranscriptionFailure(job.ID, err.Error(), next)
+		if err != nil {
+			log.Printf("Couldn't record transcription failure for %s: %v", job.ID, err)
+		}
+		return true
+	}
+
+	err = cfg.db.CompleteTranscriptionJob(job.ID, captionLanguage, note)
+	if err != nil {
+		log.Printf("Couldn't complete transcription %s: %v", job.ID, err)
+	}
+	return true
+}
+
+// transcribeVideo extracts a video's audio, transcribes it and saves the
+// result as the video's auto-generated caption track for the spoken
+// language. It returns the track's language, or a note explaining why no
+// track was saved.
+func (cfg *apiConfig) transcribeVideo(ctx context.Context, job database.TranscriptionJob) (string, string, error) {
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return "", "", err
+	}
+	if video.VideoSHA256 == nil {
+		return "", "", errNoVideoFile
+	}
+
+	videoPath, cleanup, err := cfg.fetchVideoBlob(ctx, *video.VideoSHA256)
+	if err != nil {
+		return "", "", err
+	}
+	defer cleanup()
+
+	audioPath, err := extractAudio(ctx, cfg.media, videoPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(audioPath)
+
+	result, err := cfg.transcriber.Transcribe(ctx, audioPath, transcriberLanguage(job.Language))
+	if err != nil {
+		return "", "", err
+	}
+	vtt, duration, err := captions.ToWebVTT(result.VTT)
+	if err != nil {
+		return "", "", fmt.Errorf("transcriber output: %w", err)
+	}
+
+	// A requested regional tag such as pt-BR names the track, though the
+	// transcriber only knew the language.
+	spoken := result.Language
+	if job.Language != transcribe.AutoDetect {
+		spoken = job.Language
+	}
+	language, ok := canonicalLanguage(spoken)
+	if !ok {
+		language = undeterminedCaptionLanguage
+	}
+
+	// Uploaded captions are better than anything a model produces.
+	old, err := cfg.db.GetVideoCaption(video.ID, language)
+	if err != nil {
+		return "", "", err
+	}
+	if old.VideoID != uuid.Nil && !old.AutoGenerated {
+		return "", "video already has uploaded captions in " + language, nil
+	}
+
+	converted, err := hashToTempFile(bytes.NewReader(vtt), "tubely-captions.vtt")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(converted.Path)
+
+	blob, captionURL, err := cfg.storeCaptionBlob(ctx, video.ID, converted)
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = cfg.db.UpsertVideoCaption(database.UpsertVideoCaptionParams{
+		VideoID:       video.ID,
+		Language:      language,
+		Label:         language + " (auto-generated)",
+		URL:           captionURL,
+		SHA256:        blob.SHA256,
+		Storage:       blob.Storage,
+		DurationMS:    duration.Milliseconds(),
+		AutoGenerated: true,
+	})
+	if err != nil {
+		cfg.releaseBlob(blob.Storage, &blob.SHA256)
+		return "", "", err
+	}
+	if old.VideoID != uuid.Nil {
+		cfg.releaseBlob(old.Storage, &old.SHA256)
+	}
+	cfg.invalidateCaptionPlaylists(video)
+	return language, "", nil
+}
+
+// dropAutoCaptions deletes the auto-generated caption tracks of a video
+// whose file was replaced, since they were transcribed from the old one.
+// Uploaded tracks are kept. Failures are logged: the new file is already
+// saved, and the tracks are transcribed again anyway.
+func (cfg *apiConfig) dropAutoCaptions(video database.Video) {
+	videoCaptions, err := cfg.db.GetVideoCaptions(video.ID)
+	if err != nil {
+		log.Printf("Couldn't retrieve captions for video %s: %v", video.ID, err)
+		return
+	}
+	dropped := []*string{}
+	for _, caption := range videoCaptions {
+		if !caption.AutoGenerated {
+			continue
+		}
+		err = cfg.db.DeleteVideoCaption(video.ID, caption.Language)
+		if err != nil {
+			log.Printf("Couldn't delete %s captions for video %s: %v", caption.Language, video.ID, err)
+			continue
+		}
+		cfg.releaseBlob(caption.Storage, &caption.SHA256)
+		dropped = append(dropped, &caption.URL)
+	}
+	if len(dropped) > 0 {
+		cfg.invalidateCDN("auto-generated captions dropped for video "+video.ID.String(), dropped...)
+		cfg.invalidateCaptionPlaylists(video)
+	}
+}
+
+// transcriberLanguage is the language a transcriber is asked for: the
+// primary subtag of a BCP 47 tag, which for two-letter languages is the
+// ISO 639-1 code speech models take.
+func transcriberLanguage(language string) string {
+	primary, _, _ := strings.Cut(language, "-")
+	return primary
+}
+
+// fetchVideoBlob returns a local path to a stored video, downloading it
+// from S3 if need be. The caller must call cleanup when done with it.
+func (cfg *apiConfig) fetchVideoBlob(ctx context.Context, sha string) (string, func(), error) {
+	blob, err := cfg.db.GetBlob(cfg.videoBlobStorage(), sha)
+	if err != nil {
+		return "", nil, err
+	}
+	if blob.SHA256 == "" {
+		return "", nil, errNoVideoFile
+	}
+	if blob.Storage != blobStorageS3 {
+		return cfg.localBlobPath(blob), func() {}, nil
+	}
+
+	out, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cfg.s3Bucket),
+		Key:    aws.String(blob.Key),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	defer out.Body.Close()
+
+	f, err := os.CreateTemp("", "tubely-transcribe*"+filepath.Ext(blob.Key))
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	cleanup := func() { os.Remove(f.Name()) }
+	if _, err := io.Copy(f, out.Body); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// extractAudio writes a video's first audio track as the 16 kHz mono WAV
+// that transcribers take. The caller must remove the file.
+func extractAudio(ctx context.Context, media ffmpeg.MediaTool, videoPath string) (string, error) {
+	f, err := os.CreateTemp("", "tubely-audio*.wav")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	_, err = media.Transcode(ctx, "-v", "error", "-y", "-i", videoPath,
+		"-map", "0:a:0", "-vn", "-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le", f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// newTranscriber builds the transcriber named by TRANSCRIBER, or returns
+// nil when automatic captions are off.
+func newTranscriber(name string) (transcribe.Transcriber, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "whisper.cpp":
+		model := os.Getenv("WHISPER_MODEL")
+		if model == "" {
+			return nil, errors.New("WHISPER_MODEL must be set")
+		}
+		whisper := transcribe.WhisperCPP{
+			Binary: os.Getenv("WHISPER_BINARY"),
+			Model:  model,
+		}
+		if whisper.Binary == "" {
+			whisper.Binary = "whisper-cli"
+		}
+		if threadsString := os.Getenv("WHISPER_THREADS"); threadsString != "" {
+			threads, err := strconv.Atoi(threadsString)
+			if err != nil {
+				return nil, fmt.Errorf("invalid WHISPER_THREADS: %w", err)
+			}
+			whisper.Threads = threads
+		}
+		return whisper, nil
+	default:
+		return nil, fmt.Errorf("unknown transcriber %q", name)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
+)
+
+func TestTranscriptionJob(t *testing.T) {
+	tests := []struct {
+		name            string
+		requested       string
+		wantTranscriber string
+		wantCaption     string
+	}{
+		{name: "language", requested: "fr", wantTranscriber: "fr", wantCaption: "fr"},
+		{name: "regional tag", requested: "pt-BR", wantTranscriber: "pt", wantCaption: "pt-BR"},
+		{name: "script tag", requested: "zh-Hant", wantTranscriber: "zh", wantCaption: "zh-Hant"},
+		{name: "detected", requested: transcribe.AutoDetect, wantTranscriber: transcribe.AutoDetect, wantCaption: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			transcriber := &transcribe.Fake{}
+			cfg.transcriber = transcriber
+			user, _ := newTestUser(t, cfg, "owner@example.com")
+			video := newTestVideo(t, cfg, user)
+
+			upload, err := hashFile(writeTestFile(t, "video.mp4", "video"))
+			if err != nil {
+				t.Fatalf("hashFile: %v", err)
+			}
+			blob, videoURL, err := cfg.storeVideoBlob(context.Background(), video.ID, upload, "", ".mp4", "video/mp4")
+			if err != nil {
+				t.Fatalf("storeVideoBlob: %v", err)
+			}
+			video.VideoURL, video.VideoSHA256 = &videoURL, &blob.SHA256
+			err = cfg.db.UpdateVideo(video, nil)
+			if err != nil {
+				t.Fatalf("UpdateVideo: %v", err)
+			}
+
+			job, err := cfg.db.CreateTranscriptionJob(video.ID, tt.requested)
+			if err != nil {
+				t.Fatalf("CreateTranscriptionJob: %v", err)
+			}
+			if !cfg.runNextTranscription() {
+				t.Fatal("runNextTranscription found no job")
+			}
+
+			calls := transcriber.Calls()
+			if len(calls) != 1 {
+				t.Fatalf("transcriber called %d times, want 1", len(calls))
+			}
+			if calls[0].Language != tt.wantTranscriber {
+				t.Errorf("transcriber asked for %q, want %q", calls[0].Language, tt.wantTranscriber)
+			}
+			if _, err := os.Stat(calls[0].AudioPath); err == nil {
+				t.Errorf("extracted audio %s wasn't removed", calls[0].AudioPath)
+			}
+
+			jobs, err := cfg.db.GetTranscriptionJobs(video.ID)
+			if err != nil {
+				t.Fatalf("GetTranscriptionJobs: %v", err)
+			}
+			if len(jobs) != 1 || jobs[0].ID != job.ID {
+				t.Fatalf("jobs = %+v, want just %s", jobs, job.ID)
+			}
+			if jobs[0].Status != database.TranscriptionDone || jobs[0].CaptionLanguage != tt.wantCaption {
+				t.Errorf("job is %s with caption %q, want %s with %q", jobs[0].Status, jobs[0].CaptionLanguage, database.TranscriptionDone, tt.wantCaption)
+			}
+
+			caption, err := cfg.db.GetVideoCaption(video.ID, tt.wantCaption)
+			if err != nil {
+				t.Fatalf("GetVideoCaption: %v", err)
+			}
+			if !caption.AutoGenerated {
+				t.Fatalf("caption %+v isn't an auto-generated track", caption)
+			}
+			if caption.Storage != blobStorageVideos {
+				t.Errorf("caption stored in %q, want %q", caption.Storage, blobStorageVideos)
+			}
+		})
+	}
+}
+
+func TestTranscriptionJobKeepsUploadedCaptions(t *testing.T) {
+	cfg := newTestConfig(t)
+	transcriber := &transcribe.Fake{}
+	cfg.transcriber = transcriber
+	user, _ := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, user)
+
+	upload, err := hashFile(writeTestFile(t, "video.mp4", "video"))
+	if err != nil {
+		t.Fatalf("hashFile: %v", err)
+	}
+	blob, videoURL, err := cfg.storeVideoBlob(context.Background(), video.ID, upload, "", ".mp4", "video/mp4")
+	if err != nil {
+		t.Fatalf("storeVideoBlob: %v", err)
+	}
+	video.VideoURL, video.VideoSHA256 = &videoURL, &blob.SHA256
+	err = cfg.db.UpdateVideo(video, nil)
+	if err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	uploaded, err := cfg.db.UpsertVideoCaption(database.UpsertVideoCaptionParams{
+		VideoID:  video.ID,
+		Language: "pt-BR",
+		Label:    "Português",
+		URL:      "http://tubely.test/captions.vtt",
+		SHA256:   "uploaded",
+		Storage:  blobStorageVideos,
+	})
+	if err != nil {
+		t.Fatalf("UpsertVideoCaption: %v", err)
+	}
+
+	_, err = cfg.db.CreateTranscriptionJob(video.ID, "pt-BR")
+	if err != nil {
+		t.Fatalf("CreateTranscriptionJob: %v", err)
+	}
+	cfg.runNextTranscription()
+
+	caption, err := cfg.db.GetVideoCaption(video.ID, "pt-BR")
+	if err != nil {
+		t.Fatalf("GetVideoCaption: %v", err)
+	}
+	if caption.AutoGenerated || caption.SHA256 != uploaded.SHA256 {
+		t.Errorf("uploaded caption was replaced by %+v", caption)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// streamTokenTTL bounds how long a private video URL handed to a client
+// keeps working. Players keep issuing range requests while playing, so it
+// must outlast a viewing session.
+const streamTokenTTL = 6 * time.Hour
+
+// usesLocalVideoStorage reports whether videos are kept on local disk
+// rather than in S3.
+func (cfg *apiConfig) usesLocalVideoStorage() bool {
+	return cfg.s3Client == nil
+}
+
+// localVideoDir is the directory holding files specific to a video, such as
+// an HLS playlist with its segments. Uploaded mp4s are stored as blobs.
+func (cfg *apiConfig) localVideoDir(videoID uuid.UUID) string {
+	return filepath.Join(cfg.videosRoot, videoID.String())
+}
+
+func (cfg *apiConfig) localStreamURL(videoID uuid.UUID, fileName string) string {
+	streamPath := "api/videos/" + videoID.String() + "/stream/" + fileName
+	if cfg.edge != nil {
+		return cfg.publicURL + "/edge/" + streamPath
+	}
+	return cfg.publicURL + "/" + streamPath
+}
+
+// distributionURL is where clients fetch an S3 object from: CloudFront, or
+// the built-in edge cache when it stands in for it.
+func (cfg *apiConfig) distributionURL(key string) string {
+	if cfg.edge != nil {
+		return cfg.publicURL + "/edge/" + key
+	}
+	return "https://" + cfg.s3CfDistribution + "/" + key
+}
+
+// videoBlobStorage is where video files, and the audio and captions kept
+// with them, are stored.
+func (cfg *apiConfig) videoBlobStorage() string {
+	if cfg.usesLocalVideoStorage() {
+		return blobStorageVideos
+	}
+	return blobStorageS3
+}
+
+// storeVideoBlob stores a video file wherever videos are kept and returns
+// the blob together with the URL clients fetch it from. keyPrefix only
+// applies in S3, where it groups objects by kind.
+func (cfg *apiConfig) storeVideoBlob(ctx context.Context, videoID uuid.UUID, f hashedFile, keyPrefix, ext, contentType string) (database.Blob, string, error) {
+	if cfg.usesLocalVideoStorage() {
+		keyPrefix = ""
+	}
+	blob, err := cfg.storeBlob(ctx, cfg.videoBlobStorage(), f, keyPrefix, ext, contentType)
+	if err != nil {
+		return database.Blob{}, "", err
+	}
+	return blob, cfg.videoBlobURL(videoID, blob), nil
+}
+
+// videoBlobURL is the URL clients fetch a blob stored by storeVideoBlob
+// for a video from.
+func (cfg *apiConfig) videoBlobURL(videoID uuid.UUID, blob database.Blob) string {
+	if blob.Storage == blobStorageVideos {
+		return cfg.localStreamURL(videoID, blob.Key)
+	}
+	return cfg.distributionURL(blob.Key)
+}
+
+// deleteLocalVideo removes a video's files, if it has any on local disk.
+func (cfg *apiConfig) deleteLocalVideo(videoID uuid.UUID) error {
+	if !cfg.usesLocalVideoStorage() {
+		return nil
+	}
+	return os.RemoveAll(cfg.localVideoDir(videoID))
+}
+
+// videoForViewer prepares a video for a response to userID: it adds the
+// caption tracks and chapters, and private videos get a stream token in
+// URLs the API serves, captions' included, since media elements can't
+// authenticate with a header.
+func (cfg *apiConfig) videoForViewer(video database.Video, userID uuid.UUID) (database.Video, error) {
+	captions, err := cfg.db.GetVideoCaptions(video.ID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.Captions = captions
+	video.Chapters, err = cfg.db.GetVideoChapters(video.ID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if len(video.Chapters) > 0 {
+		chaptersURL := cfg.chaptersURL(video)
+		video.ChaptersURL = &chaptersURL
+	}
+
+	if !video.IsPrivate {
+		return video, nil
+	}
+	// Matched loosely so URLs stored before the edge cache was toggled
+	// still get a token.
+	apiPath := "/api/videos/" + video.ID.String() + "/"
+	token := ""
+	withToken := func(u *string) (*string, error) {
+		if u == nil || !strings.Contains(*u, apiPath) {
+			return u, nil
+		}
+		if token == "" {
+			var err error
+			token, err = auth.MakeStreamToken(userID, video.ID, cfg.jwtKeys, streamTokenTTL)
+			if err != nil {
+				return nil, err
+			}
+		}
+		tokenized := *u + "?token=" + url.QueryEscape(token)
+		return &tokenized, nil
+	}
+
+	for i := range video.Captions {
+		captionURL, err := withToken(&video.Captions[i].URL)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.Captions[i].URL = *captionURL
+	}
+
+	video.VideoURL, err = withToken(video.VideoURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.SourceURL, err = withToken(video.SourceURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.AudioURL, err = withToken(video.AudioURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.PreviewURL, err = withToken(video.PreviewURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.PreviewMP4URL, err = withToken(video.PreviewMP4URL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.StoryboardURL, err = withToken(video.StoryboardURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.ChaptersURL, err = withToken(video.ChaptersURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	return video, nil
+}
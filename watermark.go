@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ffmpeg"
+	"github.com/google/uuid"
+)
+
+const (
+	errCodeInvalidWatermark = "invalid_watermark"
+
+	// watermarkRelativeWidth sizes the logo against the video, so it looks
+	// the same at every resolution.
+	watermarkRelativeWidth = 0.15
+	maxWatermarkMargin     = 500
+
+	defaultWatermarkPosition = "bottom-right"
+	defaultWatermarkOpacity  = 0.8
+	defaultWatermarkMargin   = 24
+)
+
+var watermarkPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+// watermark is a logo to overlay on videos and how to place it.
+type watermark struct {
+	// ImagePath is a PNG or JPEG on local disk.
+	ImagePath string
+	Position  string
+	Opacity   float64
+	Margin    int
+}
+
+// validate checks the placement settings, returning an error suitable for
+// the client.
+func (wm watermark) validate() error {
+	if !slices.Contains(watermarkPositions, wm.Position) {
+		return fmt.Errorf("position must be one of %v", watermarkPositions)
+	}
+	if wm.Opacity <= 0 || wm.Opacity > 1 {
+		return errors.New("opacity must be above 0 and at most 1")
+	}
+	if wm.Margin < 0 || wm.Margin > maxWatermarkMargin {
+		return fmt.Errorf("margin must be between 0 and %d pixels", maxWatermarkMargin)
+	}
+	return nil
+}
+
+// overlayFilter is the ffmpeg filter graph stamping input 1, the logo, on
+// input 0, the video.
+func (wm watermark) overlayFilter() string {
+	x, y := "main_w-overlay_w-"+strconv.Itoa(wm.Margin), "main_h-overlay_h-"+strconv.Itoa(wm.Margin)
+	switch wm.Position {
+	case "top-left":
+		x, y = strconv.Itoa(wm.Margin), strconv.Itoa(wm.Margin)
+	case "top-right":
+		y = strconv.Itoa(wm.Margin)
+	case "bottom-left":
+		x = strconv.Itoa(wm.Margin)
+	case "center":
+		x, y = "(main_w-overlay_w)/2", "(main_h-overlay_h)/2"
+	}
+	return fmt.Sprintf("[1:v][0:v]scale2ref=w=main_w*%g:h=ow/dar[logo][base];"+
+		"[logo]format=rgba,colorchannelmixer=aa=%g[wm];"+
+		"[base][wm]overlay=x=%s:y=%s:format=auto,format=yuv420p[v]",
+		watermarkRelativeWidth, wm.Opacity, x, y)
+}
+
+// applyWatermark re-encodes a video with the watermark overlaid, copying
+// the audio. The caller must remove the new file.
+func applyWatermark(ctx context.Context, media ffmpeg.MediaTool, path string, wm watermark) (string, error) {
+	outputFilePath := path + ".watermarked.mp4"
+	args := []string{"-v", "error", "-y", "-i", path, "-i", wm.ImagePath,
+		"-filter_complex", wm.overlayFilter(), "-map", "[v]", "-map", "0:a:0?"}
+	args = append(args, h264EncodeArgs...)
+	args = append(args, "-c:a", "copy", "-f", "mp4", outputFilePath)
+	_, err := media.Transcode(ctx, args...)
+	if err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
+
+// renderWatermarkPreview writes the frame at offset with the watermark
+// overlaid as a JPEG. The caller must remove the file.
+func renderWatermarkPreview(ctx context.Context, media ffmpeg.MediaTool, videoPath string, offset time.Duration, wm watermark) (string, error) {
+	f, err := os.CreateTemp("", "tubely-watermark-preview*.jpg")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	_, err = media.Transcode(ctx, "-v", "error", "-y",
+		"-ss", fmt.Sprintf("%.3f", offset.Seconds()), "-i", videoPath, "-i", wm.ImagePath,
+		"-filter_complex", wm.overlayFilter(), "-map", "[v]",
+		"-frames:v", "1", "-q:v", "3", "-f", "image2", "-c:v", "mjpeg", f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// watermarkFor returns the watermark for videos owned by userID: their own
+// if they set one, otherwise the deployment's. ok is false when there is
+// neither.
+func (cfg *apiConfig) watermarkFor(userID uuid.UUID) (watermark, bool, error) {
+	userWatermark, err := cfg.db.GetUserWatermark(userID)
+	if err != nil {
+		return watermark{}, false, err
+	}
+	if userWatermark.UserID != uuid.Nil {
+		wm, err := cfg.userWatermark(userWatermark)
+		if err != nil {
+			return watermark{}, false, err
+		}
+		return wm, true, nil
+	}
+	if cfg.watermark != nil {
+		return *cfg.watermark, true, nil
+	}
+	return watermark{}, false, nil
+}
+
+// userWatermark locates a user's stored logo.
+func (cfg *apiConfig) userWatermark(userWatermark database.UserWatermark) (watermark, error) {
+	blob, err := cfg.db.GetBlob(blobStorageAssets, userWatermark.ImageSHA256)
+	if err != nil {
+		return watermark{}, err
+	}
+	if blob.SHA256 == "" {
+		return watermark{}, fmt.Errorf("watermark image %s is missing", userWatermark.ImageSHA256)
+	}
+	return watermark{
+		ImagePath: cfg.localBlobPath(blob),
+		Position:  userWatermark.Position,
+		Opacity:   userWatermark.Opacity,
+		Margin:    userWatermark.Margin,
+	}, nil
+}
+
+// watermarkFromEnv reads the deployment's watermark, or returns nil when
+// WATERMARK_IMAGE isn't set.
+func watermarkFromEnv() (*watermark, error) {
+	imagePath := os.Getenv("WATERMARK_IMAGE")
+	if imagePath == "" {
+		return nil, nil
+	}
+	_, err := checkMediaType("", imagePath, thumbnailMediaTypes)
+	if err != nil {
+		return nil, fmt.Errorf("WATERMARK_IMAGE: %w", err)
+	}
+	err = validateImage(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("WATERMARK_IMAGE: %w", err)
+	}
+
+	wm := watermark{
+		ImagePath: imagePath,
+		Position:  defaultWatermarkPosition,
+		Opacity:   defaultWatermarkOpacity,
+		Margin:    defaultWatermarkMargin,
+	}
+	if position := os.Getenv("WATERMARK_POSITION"); position != "" {
+		wm.Position = position
+	}
+	if opacityString := os.Getenv("WATERMARK_OPACITY"); opacityString != "" {
+		wm.Opacity, err = strconv.ParseFloat(opacityString, 64)
+		if err != nil {
+			return nil, fmt.Errorf("WATERMARK_OPACITY: %w", err)
+		}
+	}
+	if marginString := os.Getenv("WATERMARK_MARGIN"); marginString != "" {
+		wm.Margin, err = strconv.Atoi(marginString)
+		if err != nil {
+			return nil, fmt.Errorf("WATERMARK_MARGIN: %w", err)
+		}
+	}
+	err = wm.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &wm, nil
+}